@@ -6,9 +6,12 @@ import (
 	"flag"
 	"html/template"
 	stdlog "log"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/bwplotka/correlator/pkg/correlator"
 	"github.com/go-kit/log"
@@ -20,6 +23,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/version"
+	"github.com/prometheus/prometheus/promql/parser"
 )
 
 const correlatorVersion = "v0.1.0"
@@ -34,6 +38,17 @@ const html = `
             Alert Firing? Tell me the Alert Name! <input type="alertname" name="alertname">
 			</br>
 			Wanna us to use Exemplars too? <input type="checkbox" name="useExemplar">
+			</br>
+			Alert fires for many instances? Pick one by labels (e.g. {instance="pod-1"}) <input type="text" name="matchers">
+			or by fingerprint <input type="text" name="fingerprint">
+			</br>
+			No alert, but you have a PromQL query? <input type="text" name="query">
+			from <input type="text" name="start"> to <input type="text" name="end"> (RFC3339 or unix seconds, defaults to the last hour)
+			</br>
+			No alert, but you have a Trace ID? <input type="text" name="traceid">
+			</br>
+			Multi-tenant backends? Your tenant <input type="text" name="tenant">
+			</br>
             <input type="submit" value="Correlate">
         </form>
     </body>
@@ -44,6 +59,14 @@ var (
 	addr       = flag.String("listen-address", ":8080", "The address to listen on for HTTP requests.")
 	configFile = flag.String("config-file", "", "Configuration file.")
 	config     = flag.String("config", "", "YAML content for the configuration file.")
+
+	correlateTimeout = flag.Duration("correlate.timeout", 1*time.Minute, "Timeout of a single /correlate request. Correlations not finished in time are returned with error.")
+	tenantHeader     = flag.String("tenant-header", "", "Optional HTTP header with the tenant of the request, e.g. set by authenticating proxy. If set, tenant parameter is ignored.")
+
+	webhookTimeout    = flag.Duration("webhook.timeout", 1*time.Minute, "Timeout of correlating a single alert received from Alertmanager webhook, and of forwarding its correlation.")
+	webhookQueueSize  = flag.Int("webhook.queue-size", 100, "Maximum number of Alertmanager webhook messages waiting for correlation. Messages over the limit are rejected with 503 status code, so Alertmanager retries them.")
+	webhookMaxStored  = flag.Int("webhook.max-stored", 100, "Maximum number of alert correlations from Alertmanager webhook kept in memory.")
+	webhookForwardURL = flag.String("webhook.forward-url", "", "Optional URL each alert correlation from Alertmanager webhook is POSTed to as JSON.")
 )
 
 func main() {
@@ -54,9 +77,18 @@ func main() {
 	}
 }
 
+// errorResponse is the body of failed API responses.
+type errorResponse struct {
+	Error *correlator.Error `json:"error"`
+}
+
 func httpErrHandle(w http.ResponseWriter, code int, err error) {
+	b, merr := json.Marshal(errorResponse{Error: correlator.NewError(err)})
+	if merr != nil {
+		b = []byte(`{"error":{"code":"internal","message":"failed to encode error"}}`)
+	}
 	w.WriteHeader(code)
-	_, _ = w.Write([]byte("{ \"error\": \" " + err.Error() + "\"}"))
+	_, _ = w.Write(b)
 }
 
 func runMain() (err error) {
@@ -74,6 +106,9 @@ func runMain() (err error) {
 	if *config != "" && *configFile != "" {
 		return errors.New("can't set both -config and -config-file!")
 	}
+	if *webhookQueueSize < 0 {
+		return errors.New("-webhook.queue-size can't be negative!")
+	}
 
 	var cfg correlator.Config
 	if *config != "" {
@@ -116,38 +151,77 @@ func runMain() (err error) {
 	})
 
 	m.HandleFunc("/correlate", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Content-Type", "application/json; charset=utf-8")
+		streamType := streamContentType(r)
+		if streamType == "" {
+			w.Header().Add("Content-Type", "application/json; charset=utf-8")
+		}
 
 		if err := r.ParseForm(); err != nil {
 			httpErrHandle(w, http.StatusInternalServerError, err)
 		}
 
 		in := correlator.Input{IgnoreExemplar: true}
-		
+
 		useExemplar := r.Form["useExemplar"]
 		if len(useExemplar) > 0 && useExemplar[0] == "on" {
 			in.IgnoreExemplar = false
 		}
 
-		alertName := r.Form["alertname"]
-		if len(alertName) == 0 {
-			httpErrHandle(w, http.StatusBadRequest, errors.New("alertname parameter is required."))
+		in.AlertName = r.Form.Get("alertname")
+		in.Query = r.Form.Get("query")
+		in.TraceID = r.Form.Get("traceid")
+		if in.AlertName == "" && in.Query == "" && in.TraceID == "" {
+			httpErrHandle(w, http.StatusBadRequest, errors.Wrap(correlator.ErrInvalidInput, "alertname, query or traceid parameter is required"))
 			return
 		}
-		in.AlertName = alertName[0]
-		discoveries, correlations, err := c.Correlate(r.Context(), in)
+		for param, t := range map[string]*time.Time{"start": &in.Start, "end": &in.End} {
+			v := r.Form.Get(param)
+			if v == "" {
+				continue
+			}
+			pt, err := parseTime(v)
+			if err != nil {
+				httpErrHandle(w, http.StatusBadRequest, errors.Wrapf(correlator.ErrInvalidInput, "parse %v parameter: %v", param, err))
+				return
+			}
+			*t = pt
+		}
+
+		if matchers := r.Form.Get("matchers"); matchers != "" {
+			ms, err := parser.ParseMetricSelector(matchers)
+			if err != nil {
+				httpErrHandle(w, http.StatusBadRequest, errors.Wrapf(correlator.ErrInvalidInput, "parse matchers parameter: %v", err))
+				return
+			}
+			in.AlertMatchers = ms
+		}
+		in.AlertFingerprint = r.Form.Get("fingerprint")
+		in.Tenant = requestTenant(r)
+
+		ctx, cancel := context.WithTimeout(r.Context(), *correlateTimeout)
+		defer cancel()
+
+		if streamType != "" {
+			// Stream each result as soon as it is produced, finishing with the status event.
+			sw := newStreamWriter(w, streamType)
+			err := c.CorrelateStream(ctx, in, func(res correlator.Result) {
+				if err := sw.result(res); err != nil {
+					level.Warn(logger).Log("msg", "failed to write streamed result", "err", err)
+				}
+			})
+			if err := sw.status(err); err != nil {
+				level.Warn(logger).Log("msg", "failed to write stream status", "err", err)
+			}
+			return
+		}
+
+		resp, err := c.Correlate(ctx, in)
 		if err != nil {
-			httpErrHandle(w, http.StatusInternalServerError, err)
+			httpErrHandle(w, correlator.NewError(err).Code.HTTPStatus(), err)
 			return
 		}
 
-		b, err := json.Marshal(struct {
-			Discoveries  []correlator.Discovery
-			Correlations []correlator.Correlation
-		}{
-			Discoveries:  discoveries,
-			Correlations: correlations,
-		})
+		b, err := json.Marshal(resp)
 		if err != nil {
 			httpErrHandle(w, http.StatusInternalServerError, err)
 			return
@@ -157,9 +231,21 @@ func runMain() (err error) {
 		_, _ = w.Write(b)
 	})
 
+	wr := newWebhookReceiver(logger, c, *webhookTimeout, *webhookQueueSize, *webhookMaxStored, *webhookForwardURL, &http.Client{Timeout: *webhookTimeout})
+	m.Handle("/api/v1/alertmanager/webhook", wr)
+	m.HandleFunc("/api/v1/alertmanager/correlations", wr.serveStored)
+
 	srv := http.Server{Addr: *addr, Handler: m}
 
 	g := &run.Group{}
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return wr.run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
 	g.Add(func() error {
 		level.Info(logger).Log("msg", "starting HTTP server", "addr", *addr)
 		if err := srv.ListenAndServe(); err != nil {
@@ -174,3 +260,25 @@ func runMain() (err error) {
 	g.Add(run.SignalHandler(context.Background(), syscall.SIGINT, syscall.SIGTERM))
 	return g.Run()
 }
+
+// requestTenant returns the tenant of the request, from the header given by -tenant-header flag or tenant
+// parameter otherwise.
+func requestTenant(r *http.Request) string {
+	if *tenantHeader != "" {
+		return r.Header.Get(*tenantHeader)
+	}
+	return r.FormValue("tenant")
+}
+
+// parseTime parses time given as RFC3339 or unix timestamp in seconds (with optional decimal fraction), as
+// Prometheus HTTP API does.
+func parseTime(s string) (time.Time, error) {
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, ns := math.Modf(t)
+		return time.Unix(int64(sec), int64(math.Round(ns*1e3))*int64(time.Millisecond)).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.Errorf("cannot parse %q to a valid timestamp", s)
+}
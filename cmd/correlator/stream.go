@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/bwplotka/correlator/pkg/correlator"
+)
+
+const (
+	contentTypeSSE    = "text/event-stream"
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+// streamStatus is the last event of the stream.
+type streamStatus struct {
+	Status string
+	Error  *correlator.Error `json:",omitempty"`
+}
+
+// streamContentType returns streaming content type requested in Accept header or empty string if the client
+// did not ask for streaming response.
+func streamContentType(r *http.Request) string {
+	for _, a := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(a))
+		if err != nil {
+			continue
+		}
+		if mt == contentTypeSSE || mt == contentTypeNDJSON {
+			return mt
+		}
+	}
+	return ""
+}
+
+// streamWriter writes and flushes each event as soon as it is written, either as Server-Sent Event or
+// newline delimited JSON.
+type streamWriter struct {
+	w           io.Writer
+	f           http.Flusher
+	contentType string
+}
+
+func newStreamWriter(w http.ResponseWriter, contentType string) *streamWriter {
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	f, _ := w.(http.Flusher)
+	return &streamWriter{w: w, f: f, contentType: contentType}
+}
+
+// result writes the whole result, so clients can group it by Alert and Selector. SSE event is named after the set
+// result field.
+func (s *streamWriter) result(r correlator.Result) error {
+	switch {
+	case r.Discovery != nil:
+		return s.event("discovery", r)
+	case r.Correlation != nil:
+		return s.event("correlation", r)
+	}
+	return nil
+}
+
+func (s *streamWriter) status(err error) error {
+	st := streamStatus{Status: "success"}
+	if err != nil {
+		st = streamStatus{Status: "error", Error: correlator.NewError(err)}
+	}
+	return s.event("status", st)
+}
+
+func (s *streamWriter) event(name string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if s.contentType == contentTypeSSE {
+		_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, b)
+	} else {
+		_, err = fmt.Fprintf(s.w, "%s\n", b)
+	}
+	if err != nil {
+		return err
+	}
+	if s.f != nil {
+		s.f.Flush()
+	}
+	return nil
+}
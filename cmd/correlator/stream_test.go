@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bwplotka/correlator/pkg/correlator"
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/prometheus/common/model"
+)
+
+func TestStreamWriter_Result(t *testing.T) {
+	res := correlator.Result{
+		Index:       3,
+		Alert:       model.LabelSet{"alertname": "PingService_TooManyErrors"},
+		Selector:    `http_requests_total{job="ping"}`,
+		Correlation: &correlator.Correlation{Description: "Log View", URL: "http://loki", Source: "loki", Status: correlator.CorrelationUnknown},
+	}
+	const data = `{"Index":3,"Alert":{"alertname":"PingService_TooManyErrors"},"Selector":"http_requests_total{job=\"ping\"}",` +
+		`"Correlation":{"Description":"Log View","URL":"http://loki","Source":"loki","Status":"unknown"}}`
+
+	rec := httptest.NewRecorder()
+	s := newStreamWriter(rec, contentTypeSSE)
+	testutil.Ok(t, s.result(res))
+	testutil.Ok(t, s.status(nil))
+	testutil.Equals(t, "event: correlation\ndata: "+data+"\n\nevent: status\ndata: {\"Status\":\"success\"}\n\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	s = newStreamWriter(rec, contentTypeNDJSON)
+	testutil.Ok(t, s.result(res))
+	testutil.Equals(t, data+"\n", rec.Body.String())
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bwplotka/correlator/pkg/correlator"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// webhookMessage is the Alertmanager webhook payload, see
+// https://prometheus.io/docs/alerting/latest/configuration/#webhook_config.
+type webhookMessage struct {
+	Version  string         `json:"version"`
+	GroupKey string         `json:"groupKey"`
+	Status   string         `json:"status"`
+	Receiver string         `json:"receiver"`
+	Alerts   []webhookAlert `json:"alerts"`
+
+	// tenant is the tenant of the webhook request, e.g. given in the webhook URL. It's not part of the payload.
+	tenant string
+}
+
+type webhookAlert struct {
+	Status       string         `json:"status"`
+	Labels       model.LabelSet `json:"labels"`
+	Annotations  model.LabelSet `json:"annotations"`
+	StartsAt     time.Time      `json:"startsAt"`
+	EndsAt       time.Time      `json:"endsAt"`
+	GeneratorURL string         `json:"generatorURL"`
+	Fingerprint  string         `json:"fingerprint"`
+}
+
+// webhookCorrelation is the correlation of a single alert received from Alertmanager.
+type webhookCorrelation struct {
+	ReceivedAt  time.Time
+	Tenant      string `json:",omitempty"`
+	GroupKey    string
+	Fingerprint string
+	Labels      model.LabelSet
+	StartsAt    time.Time
+	Response    *correlator.Response `json:",omitempty"`
+	Error       *correlator.Error    `json:",omitempty"`
+}
+
+// webhookResponse is the response to Alertmanager webhook request.
+type webhookResponse struct {
+	// Queued is the number of firing alerts queued for correlation.
+	Queued int
+}
+
+// webhookReceiver correlates alerts received from Alertmanager in the background, so Alertmanager does not wait
+// for (and retry) slow correlations. Results are stored in memory and optionally forwarded to another endpoint.
+type webhookReceiver struct {
+	logger     log.Logger
+	c          *correlator.Correlator
+	timeout    time.Duration
+	forwardURL string
+	// client is used to forward correlations, its Timeout limits forwarding, so a hanging endpoint does not block
+	// correlations of next messages.
+	client *http.Client
+
+	queue chan webhookMessage
+
+	mtx       sync.Mutex
+	maxStored int
+	stored    []webhookCorrelation
+}
+
+func newWebhookReceiver(logger log.Logger, c *correlator.Correlator, timeout time.Duration, queueSize, maxStored int, forwardURL string, client *http.Client) *webhookReceiver {
+	return &webhookReceiver{
+		logger:     logger,
+		c:          c,
+		timeout:    timeout,
+		forwardURL: forwardURL,
+		client:     client,
+		queue:      make(chan webhookMessage, queueSize),
+		maxStored:  maxStored,
+	}
+}
+
+// ServeHTTP accepts Alertmanager webhook message and queues it for correlation.
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	if r.Method != http.MethodPost {
+		httpErrHandle(w, http.StatusMethodNotAllowed, errors.Wrap(correlator.ErrInvalidInput, "only POST method is allowed"))
+		return
+	}
+
+	var msg webhookMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		httpErrHandle(w, http.StatusBadRequest, errors.Wrapf(correlator.ErrInvalidInput, "decode Alertmanager webhook message: %v", err))
+		return
+	}
+	msg.tenant = requestTenant(r)
+
+	firing := 0
+	for _, a := range msg.Alerts {
+		if a.Status == string(model.AlertFiring) {
+			firing++
+		}
+	}
+	select {
+	case wr.queue <- msg:
+	default:
+		// Alertmanager will retry.
+		level.Warn(wr.logger).Log("msg", "webhook queue is full, dropping message", "groupKey", msg.GroupKey, "firing", firing)
+		httpErrHandle(w, http.StatusServiceUnavailable, errors.Errorf("too many webhook messages queued, dropped %d firing alerts", firing))
+		return
+	}
+
+	b, err := json.Marshal(webhookResponse{Queued: firing})
+	if err != nil {
+		httpErrHandle(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+// serveStored returns stored correlations of the request tenant, latest first.
+func (wr *webhookReceiver) serveStored(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+
+	tenant := requestTenant(r)
+	wr.mtx.Lock()
+	ret := make([]webhookCorrelation, 0, len(wr.stored))
+	for i := len(wr.stored) - 1; i >= 0; i-- {
+		if wr.stored[i].Tenant != tenant {
+			continue
+		}
+		ret = append(ret, wr.stored[i])
+	}
+	wr.mtx.Unlock()
+
+	b, err := json.Marshal(ret)
+	if err != nil {
+		httpErrHandle(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+// run correlates queued messages until context is canceled.
+func (wr *webhookReceiver) run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-wr.queue:
+			for _, a := range msg.Alerts {
+				if a.Status != string(model.AlertFiring) {
+					continue
+				}
+				wc := wr.correlate(ctx, msg.tenant, msg.GroupKey, a)
+				wr.store(wc)
+				if err := wr.forward(ctx, wc); err != nil {
+					level.Warn(wr.logger).Log("msg", "failed to forward webhook correlation", "url", wr.forwardURL, "err", err)
+				}
+			}
+		}
+	}
+}
+
+func (wr *webhookReceiver) correlate(ctx context.Context, tenant string, groupKey string, a webhookAlert) webhookCorrelation {
+	wc := webhookCorrelation{
+		ReceivedAt:  time.Now(),
+		Tenant:      tenant,
+		GroupKey:    groupKey,
+		Fingerprint: a.Fingerprint,
+		Labels:      a.Labels,
+		StartsAt:    a.StartsAt,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wr.timeout)
+	defer cancel()
+
+	resp, err := wr.c.Correlate(ctx, correlator.Input{
+		AlertName:     string(a.Labels[model.AlertNameLabel]),
+		AlertLabels:   a.Labels,
+		AlertStartsAt: a.StartsAt,
+		Tenant:        tenant,
+	})
+	if err != nil {
+		level.Warn(wr.logger).Log("msg", "failed to correlate alert from webhook", "labels", a.Labels, "err", err)
+		wc.Error = correlator.NewError(err)
+		return wc
+	}
+	wc.Response = &resp
+	return wc
+}
+
+func (wr *webhookReceiver) store(wc webhookCorrelation) {
+	wr.mtx.Lock()
+	defer wr.mtx.Unlock()
+
+	wr.stored = append(wr.stored, wc)
+	if len(wr.stored) > wr.maxStored {
+		wr.stored = wr.stored[len(wr.stored)-wr.maxStored:]
+	}
+}
+
+// forward sends the correlation as JSON to the forward URL, if configured.
+func (wr *webhookReceiver) forward(ctx context.Context, wc webhookCorrelation) error {
+	if wr.forwardURL == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(wc)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wr.forwardURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wr.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
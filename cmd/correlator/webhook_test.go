@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwplotka/correlator/pkg/correlator"
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/log"
+)
+
+const alertmanagerTestMessage = `{
+  "version": "4",
+  "groupKey": "{}:{alertname=\"PingService_TooManyErrors\"}",
+  "status": "firing",
+  "receiver": "correlator",
+  "alerts": [{
+    "status": "firing",
+    "labels": {"alertname": "PingService_TooManyErrors", "job": "ping"},
+    "annotations": {"summary": "Too many errors"},
+    "startsAt": "2022-05-17T10:00:00Z",
+    "endsAt": "0001-01-01T00:00:00Z",
+    "generatorURL": "http://thanos/graph",
+    "fingerprint": "%s"
+  }]
+}`
+
+func TestWebhookReceiver(t *testing.T) {
+	thanos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/rules" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"success","data":{"groups":[{"name":"ping","file":"alert.yaml","interval":15,"rules":[
+{"type":"alerting","name":"PingService_TooManyErrors","query":"sum(rate(http_requests_total{job=\"ping\"}[1m])) > 0.3","health":"ok","alerts":[]}]}]}}`))
+	}))
+	defer thanos.Close()
+
+	// Forward endpoint passes fingerprints of forwarded correlations and hangs on the first one.
+	forwarded := make(chan string, 2)
+	forward := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var wc webhookCorrelation
+		if err := json.NewDecoder(r.Body).Decode(&wc); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		forwarded <- wc.Fingerprint
+		if wc.Fingerprint == "b1b1b1b1b1b1b1b1" {
+			<-r.Context().Done()
+		}
+	}))
+	defer forward.Close()
+
+	cfg, err := correlator.ParseConfig([]byte(`
+sources:
+- type: thanos
+  internalEndpoint: ` + strings.TrimPrefix(thanos.URL, "http://") + `
+`))
+	testutil.Ok(t, err)
+	c, err := correlator.New(cfg, log.NewNopLogger())
+	testutil.Ok(t, err)
+
+	wr := newWebhookReceiver(log.NewNopLogger(), c, 10*time.Second, 10, 10, forward.URL, &http.Client{Timeout: 200 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = wr.run(ctx) }()
+
+	for _, fp := range []string{"b1b1b1b1b1b1b1b1", "c2c2c2c2c2c2c2c2"} {
+		rec := httptest.NewRecorder()
+		wr.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(strings.Replace(alertmanagerTestMessage, "%s", fp, 1))))
+		testutil.Equals(t, http.StatusOK, rec.Code)
+		testutil.Equals(t, `{"Queued":1}`, rec.Body.String())
+	}
+
+	// Hanging forward endpoint does not block forwarding of the next correlation.
+	for _, fp := range []string{"b1b1b1b1b1b1b1b1", "c2c2c2c2c2c2c2c2"} {
+		select {
+		case got := <-forwarded:
+			testutil.Equals(t, fp, got)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("correlation %v was not forwarded", fp)
+		}
+	}
+
+	var stored []webhookCorrelation
+	rec := httptest.NewRecorder()
+	wr.serveStored(rec, httptest.NewRequest(http.MethodGet, "/webhook", nil))
+	testutil.Equals(t, http.StatusOK, rec.Code)
+	testutil.Ok(t, json.Unmarshal(rec.Body.Bytes(), &stored))
+	testutil.Equals(t, 2, len(stored))
+	testutil.Equals(t, "c2c2c2c2c2c2c2c2", stored[0].Fingerprint)
+	testutil.Equals(t, "PingService_TooManyErrors", string(stored[0].Labels["alertname"]))
+	testutil.Assert(t, stored[0].Error == nil, "unexpected error %v", stored[0].Error)
+	testutil.Assert(t, stored[0].Response != nil && len(stored[0].Response.Alerts) == 1, "expected correlated alert")
+	testutil.Equals(t, 1, len(stored[0].Response.Alerts[0].Selectors))
+
+	rec = httptest.NewRecorder()
+	wr.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader("{")))
+	testutil.Equals(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestWebhookReceiver_QueueFull(t *testing.T) {
+	wr := newWebhookReceiver(log.NewNopLogger(), nil, time.Second, 1, 10, "", http.DefaultClient)
+
+	rec := httptest.NewRecorder()
+	wr.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(strings.Replace(alertmanagerTestMessage, "%s", "b1b1b1b1b1b1b1b1", 1))))
+	testutil.Equals(t, http.StatusOK, rec.Code)
+	testutil.Equals(t, `{"Queued":1}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	wr.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(strings.Replace(alertmanagerTestMessage, "%s", "c2c2c2c2c2c2c2c2", 1))))
+	testutil.Equals(t, http.StatusServiceUnavailable, rec.Code)
+	var resp errorResponse
+	testutil.Ok(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	testutil.Equals(t, "too many webhook messages queued, dropped 1 firing alerts", resp.Error.Message)
+}
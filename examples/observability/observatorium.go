@@ -111,38 +111,60 @@ groups:
 	return o, nil
 }
 
+// Label mappings translating metric labels of the demo services to labels used by other signals.
+var (
+	// Grafana Agent puts the name of the service into "jobs" label.
+	lokiLabelMapping = []correlator.LabelMappingRule{
+		{Source: "job", Target: "jobs"},
+		{Action: correlator.LabelMappingKeep, Regex: "jobs"},
+	}
+	// Services report traces with "demo:" prefixed service name.
+	jaegerLabelMapping = []correlator.LabelMappingRule{
+		{Action: correlator.LabelMappingReplace, Source: "job", Target: "service", Replacement: "demo:$1"},
+	}
+	// Parca job names are the internal endpoints of the scraped services.
+	parcaLabelMapping = []correlator.LabelMappingRule{
+		{Action: correlator.LabelMappingTemplate, Target: "job", Template: "{{ with .Labels.job }}e2e-correlation-{{ . }}:8080{{ end }}"},
+		{Action: correlator.LabelMappingKeep, Regex: "job"},
+	}
+	// Trace root span service is translated back to the metric job when correlating from trace ID.
+	traceLabelMapping = []correlator.LabelMappingRule{
+		{Action: correlator.LabelMappingReplace, Source: "service", Target: "job", Regex: "demo:(.*)"},
+		{Action: correlator.LabelMappingKeep, Regex: "job"},
+	}
+)
+
 func (o *Observatorium) StartCorrelator(env e2e.Environment, name string, parca e2e.Runnable) e2e.Runnable {
 	{
 		// BACKUP
 		c := correlator.Config{
-			Sources: correlator.Sources{
-				Thanos: correlator.ThanosSource{
-					Source: correlator.Source{
-						InternalEndpoint: o.querier.Endpoint("http"), // o.querier.InternalEndpoint("http"),
-						ExternalEndpoint: o.querier.Endpoint("http"),
-					},
+			TraceLabelMapping: traceLabelMapping,
+			Sources: []correlator.SourceConfig{
+				{
+					Type:             "thanos",
+					InternalEndpoint: o.querier.Endpoint("http"), // o.querier.InternalEndpoint("http"),
+					ExternalEndpoint: o.querier.Endpoint("http"),
 				},
-				Loki: correlator.LokiSource{
-					Source: correlator.Source{
-						InternalEndpoint: o.loki.Endpoint("http"), // o.loki.InternalEndpoint("http"),
-						ExternalEndpoint: o.loki.Endpoint("http"),
-					},
-					UISource: correlator.Source{
-						InternalEndpoint: o.grafana.Endpoint("http"),
-						ExternalEndpoint: o.grafana.Endpoint("http"),
+				{
+					Type:             "loki",
+					InternalEndpoint: o.loki.Endpoint("http"), // o.loki.InternalEndpoint("http"),
+					ExternalEndpoint: o.loki.Endpoint("http"),
+					LabelMapping:     lokiLabelMapping,
+					Config: correlator.LokiConfig{
+						GrafanaExternalEndpoint: o.grafana.Endpoint("http"),
 					},
 				},
-				Jaeger: correlator.JaegerSource{
-					Source: correlator.Source{
-						InternalEndpoint: o.jaeger.Endpoint("http"), // o.jaeger.InternalEndpoint("http"),
-						ExternalEndpoint: o.jaeger.Endpoint("http"),
-					},
+				{
+					Type:             "jaeger",
+					InternalEndpoint: o.jaeger.Endpoint("http"), // o.jaeger.InternalEndpoint("http"),
+					ExternalEndpoint: o.jaeger.Endpoint("http"),
+					LabelMapping:     jaegerLabelMapping,
 				},
-				Parca: correlator.ParcaSource{
-					Source: correlator.Source{
-						InternalEndpoint: parca.Endpoint("http"), // o.parca.InternalEndpoint("http"),
-						ExternalEndpoint: parca.Endpoint("http"),
-					},
+				{
+					Type:             "parca",
+					InternalEndpoint: parca.Endpoint("http"), // o.parca.InternalEndpoint("http"),
+					ExternalEndpoint: parca.Endpoint("http"),
+					LabelMapping:     parcaLabelMapping,
 				},
 			},
 		}
@@ -159,34 +181,33 @@ func (o *Observatorium) StartCorrelator(env e2e.Environment, name string, parca
 	f := e2e.NewInstrumentedRunnable(env, fmt.Sprintf("correlator-%s", name)).WithPorts(map[string]int{"http": 8080}, "http").Future()
 
 	c := correlator.Config{
-		Sources: correlator.Sources{
-			Thanos: correlator.ThanosSource{
-				Source: correlator.Source{
-					InternalEndpoint: o.querier.InternalEndpoint("http"),
-					ExternalEndpoint: o.querier.Endpoint("http"),
-				},
+		TraceLabelMapping: traceLabelMapping,
+		Sources: []correlator.SourceConfig{
+			{
+				Type:             "thanos",
+				InternalEndpoint: o.querier.InternalEndpoint("http"),
+				ExternalEndpoint: o.querier.Endpoint("http"),
 			},
-			Loki: correlator.LokiSource{
-				Source: correlator.Source{
-					InternalEndpoint: o.loki.InternalEndpoint("http"),
-					ExternalEndpoint: o.loki.Endpoint("http"),
-				},
-				UISource: correlator.Source{
-					InternalEndpoint: o.grafana.InternalEndpoint("http"),
-					ExternalEndpoint: o.grafana.Endpoint("http"),
+			{
+				Type:             "loki",
+				InternalEndpoint: o.loki.InternalEndpoint("http"),
+				ExternalEndpoint: o.loki.Endpoint("http"),
+				LabelMapping:     lokiLabelMapping,
+				Config: correlator.LokiConfig{
+					GrafanaExternalEndpoint: o.grafana.Endpoint("http"),
 				},
 			},
-			Jaeger: correlator.JaegerSource{
-				Source: correlator.Source{
-					InternalEndpoint: o.jaeger.InternalEndpoint("http"),
-					ExternalEndpoint: o.jaeger.Endpoint("http"),
-				},
+			{
+				Type:             "jaeger",
+				InternalEndpoint: o.jaeger.InternalEndpoint("http"),
+				ExternalEndpoint: o.jaeger.Endpoint("http"),
+				LabelMapping:     jaegerLabelMapping,
 			},
-			Parca: correlator.ParcaSource{
-				Source: correlator.Source{
-					InternalEndpoint: parca.InternalEndpoint("http"),
-					ExternalEndpoint: parca.Endpoint("http"),
-				},
+			{
+				Type:             "parca",
+				InternalEndpoint: parca.InternalEndpoint("http"),
+				ExternalEndpoint: parca.Endpoint("http"),
+				LabelMapping:     parcaLabelMapping,
 			},
 		},
 	}
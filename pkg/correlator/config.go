@@ -2,42 +2,98 @@ package correlator
 
 import (
 	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
 )
 
 type Config struct {
-	Sources Sources
+	Sources []SourceConfig
+	// Correlations are user defined correlation rules. See CorrelationRule.
+	Correlations []CorrelationRule `json:",omitempty"`
+	// TimeWindowPadding is added before the start of the correlation time window, which starts when the
+	// alert became active, minus the longest range used in its expression. Defaults to 5m.
+	TimeWindowPadding model.Duration `json:",omitempty"`
+	// Evidence configures observability data inlined in correlations.
+	Evidence EvidenceConfig `json:",omitempty"`
+	// TraceLabelMapping translates the service and resource attributes of the trace root span to scope labels
+	// (as used by alerts and metrics) when the correlation starts from a trace ID. Source LabelMapping is applied
+	// to the result. Defaults to renaming "service" to "job" label and keeping only "job" label.
+	TraceLabelMapping []LabelMappingRule `json:",omitempty"`
 }
 
-type Sources struct {
-	Thanos ThanosSource
-	Loki   LokiSource
-	Jaeger JaegerSource
-	Parca  ParcaSource
+// EvidenceConfig configures observability data inlined in correlations, so it can be seen without opening links.
+type EvidenceConfig struct {
+	// LogLines is the maximum number of log lines inlined in logs correlations. Defaults to 10, -1 disables it.
+	LogLines int `json:",omitempty"`
+	// DisableTraceSummary disables inlining summary of the trace (duration, services, failed and slowest spans)
+	// in trace correlations.
+	DisableTraceSummary bool `json:",omitempty"`
+	// TopFunctions is the maximum number of functions inlined from profiles in profiles correlations. Defaults
+	// to 10, -1 disables it.
+	TopFunctions int `json:",omitempty"`
 }
 
-type ThanosSource struct {
-	Source
+// TenancyConfig configures how the tenant of the correlation is passed to the source. Requests and links without
+// tenant are left as they are.
+type TenancyConfig struct {
+	// Header is the name of HTTP header with the tenant sent in requests to InternalEndpoint, e.g. "X-Scope-OrgID"
+	// for Loki, Tempo or Cortex, or "THANOS-TENANT" for Thanos.
+	Header string `json:",omitempty"`
+	// PathPrefix is prepended to paths of requests to InternalEndpoint, with "{tenant}" replaced by the tenant,
+	// e.g. "/api/metrics/v1/{tenant}" for Observatorium API.
+	PathPrefix string `json:",omitempty"`
+	// LinkPathPrefix is prepended to paths of links to ExternalEndpoint (or Kibana), with "{tenant}" replaced by the
+	// tenant, e.g. "/s/{tenant}" for Kibana spaces.
+	LinkPathPrefix string `json:",omitempty"`
+	// LinkQueryParam is the name of query parameter with the tenant added to links to ExternalEndpoint (or Kibana),
+	// e.g. "tenant".
+	LinkQueryParam string `json:",omitempty"`
+	// GrafanaOrgIDs maps tenants to Grafana organization IDs used in links to Grafana. Defaults to 1.
+	GrafanaOrgIDs map[string]int64 `json:",omitempty"`
 }
 
-type LokiSource struct {
-	Source
-	UISource Source
-}
-
-type JaegerSource struct {
-	Source
-}
-
-type ParcaSource struct {
-	Source
-}
-
-type Source struct {
-	Version          string
+// SourceConfig is a configuration of a single source. Source type specific options can be passed in Config field.
+type SourceConfig struct {
+	// Name uniquely identifies source. Defaults to Type if empty.
+	Name string `json:",omitempty"`
+	// Type is a registered source type, e.g. "thanos", "loki", "elasticsearch", "jaeger", "tempo",
+	// "zipkin", "parca" or "pyroscope".
+	Type             string
+	Version          string `json:",omitempty"`
 	InternalEndpoint string
 	ExternalEndpoint string
+	// Scheme is used for requests to InternalEndpoint and links to ExternalEndpoint, "http" (default) or "https".
+	// Other endpoints used in links (e.g. Grafana) use it too, unless they have their own scheme.
+	Scheme string `json:",omitempty"`
+	// HTTPClientConfig configures authentication, TLS and proxy for requests to InternalEndpoint, using
+	// Prometheus HTTP client configuration, e.g. `bearer_token_file` or `tls_config`. Relative file paths are
+	// resolved against the directory of the configuration file.
+	HTTPClientConfig *config.HTTPClientConfig `json:",omitempty"`
+	// Tenancy configures how the tenant of the correlation (Input.Tenant) is passed to multi-tenant backends.
+	Tenancy TenancyConfig `json:",omitempty"`
+	// Timeout limits every single call to the source, e.g. looking up exemplars or verifying a correlation, so a
+	// slow source does not hold up the whole correlation. Defaults to 10s.
+	Timeout model.Duration `json:",omitempty"`
+	// Matchers optionally route correlations to this source only for alerts, series or other scopes with labels
+	// matching them, e.g. `{cluster="eu1"}` for the source holding data of the eu1 cluster only. Matchers for
+	// labels missing in the scope are ignored.
+	Matchers string `json:",omitempty"`
+	// LabelMapping translates scope labels (e.g. alert or series labels) to labels used by this source. Defaults
+	// depend on the source type: "loki" and "parca" keep only "job" label, "jaeger" renames "job" to "service"
+	// label. Other types get labels as they are.
+	LabelMapping []LabelMappingRule `json:",omitempty"`
+
+	// Config is a type specific configuration, e.g. LokiConfig for "loki" type.
+	Config interface{} `json:",omitempty"`
 }
 
 func ParseConfigFromFile(cfgFile string) (Config, error) {
@@ -48,7 +104,14 @@ func ParseConfigFromFile(cfgFile string) (Config, error) {
 		return c, err
 	}
 
-	return ParseConfig(b)
+	c, err = ParseConfig(b)
+	if err != nil {
+		return c, err
+	}
+	for _, s := range c.Sources {
+		s.HTTPClientConfig.SetDirectory(filepath.Dir(cfgFile))
+	}
+	return c, nil
 }
 
 func ParseConfig(b []byte) (Config, error) {
@@ -57,5 +120,116 @@ func ParseConfig(b []byte) (Config, error) {
 	if err := yaml.Unmarshal(b, &c); err != nil {
 		return c, err
 	}
-	return c, nil
+	return c, c.Validate()
+}
+
+// Validate checks if configuration is correct and fills defaults.
+func (c *Config) Validate() error {
+	if c.TimeWindowPadding == 0 {
+		c.TimeWindowPadding = model.Duration(5 * time.Minute)
+	}
+	if c.Evidence.LogLines == 0 {
+		c.Evidence.LogLines = 10
+	}
+	if c.Evidence.TopFunctions == 0 {
+		c.Evidence.TopFunctions = 10
+	}
+	if _, err := c.traceLabelMapping(); err != nil {
+		return errors.Wrap(err, "trace label mapping")
+	}
+	names := map[string]struct{}{}
+	for i := range c.Sources {
+		s := &c.Sources[i]
+		if s.Type == "" {
+			return errors.Errorf("source %d: type is required", i)
+		}
+		if _, ok := sourceFactory(s.Type); !ok {
+			return errors.Errorf("source %d: unknown type %q, supported: %v", i, s.Type, SourceTypes())
+		}
+		if s.Name == "" {
+			s.Name = s.Type
+		}
+		if _, ok := names[s.Name]; ok {
+			return errors.Errorf("source %d: duplicated name %q, set unique name for each source of the same type", i, s.Name)
+		}
+		names[s.Name] = struct{}{}
+		if s.Timeout == 0 {
+			s.Timeout = model.Duration(10 * time.Second)
+		}
+		switch s.Scheme {
+		case "":
+			s.Scheme = "http"
+		case "http", "https":
+		default:
+			return errors.Errorf("source %v: unsupported scheme %q", s.Name, s.Scheme)
+		}
+		if s.HTTPClientConfig != nil {
+			if err := s.HTTPClientConfig.Validate(); err != nil {
+				return errors.Wrapf(err, "source %v: HTTP client config", s.Name)
+			}
+		}
+		if s.Tenancy.PathPrefix != "" && !strings.HasPrefix(s.Tenancy.PathPrefix, "/") {
+			return errors.Errorf("source %v: tenancy path prefix has to start with /", s.Name)
+		}
+		if _, err := s.matchers(); err != nil {
+			return errors.Wrapf(err, "source %v", s.Name)
+		}
+		if _, err := s.labelMapping(); err != nil {
+			return errors.Wrapf(err, "source %v", s.Name)
+		}
+	}
+	for i, r := range c.Correlations {
+		if _, err := newCorrelationRule(r); err != nil {
+			return errors.Wrapf(err, "correlation %d", i)
+		}
+		if _, ok := names[r.Source]; !ok {
+			return errors.Errorf("correlation %d: source %q not found", i, r.Source)
+		}
+	}
+	return nil
+}
+
+// matchers returns parsed Matchers.
+func (s SourceConfig) matchers() ([]*labels.Matcher, error) {
+	if s.Matchers == "" {
+		return nil, nil
+	}
+	ms, err := parser.ParseMetricSelector(s.Matchers)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse matchers")
+	}
+	return ms, nil
+}
+
+// labelMapping returns parsed LabelMapping or default one for the source type.
+func (s SourceConfig) labelMapping() (labelMapping, error) {
+	if s.LabelMapping == nil {
+		return newLabelMapping(defaultLabelMappings[s.Type])
+	}
+	return newLabelMapping(s.LabelMapping)
+}
+
+// traceLabelMapping returns parsed TraceLabelMapping or the default one.
+func (c Config) traceLabelMapping() (labelMapping, error) {
+	if c.TraceLabelMapping == nil {
+		return newLabelMapping(defaultTraceLabelMapping)
+	}
+	return newLabelMapping(c.TraceLabelMapping)
+}
+
+// NewHTTPClient returns HTTP client for requests to the source InternalEndpoint, configured with
+// HTTPClientConfig and Tenancy. Source types should use it for all requests to the backend.
+func (s SourceConfig) NewHTTPClient() (*http.Client, error) {
+	cfg := config.DefaultHTTPClientConfig
+	if s.HTTPClientConfig != nil {
+		cfg = *s.HTTPClientConfig
+	}
+	client, err := config.NewClientFromConfig(cfg, s.Name)
+	if err != nil {
+		return nil, err
+	}
+	if s.Tenancy.Header != "" || s.Tenancy.PathPrefix != "" {
+		client.Transport = &tenantRoundTripper{cfg: s.Tenancy, next: client.Transport}
+	}
+	return client, nil
 }
@@ -0,0 +1,56 @@
+package correlator
+
+import (
+	"testing"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/log"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+sources:
+- type: thanos
+  internalEndpoint: thanos:9090
+  externalEndpoint: localhost:9090
+- type: loki
+  internalEndpoint: loki:3100
+  externalEndpoint: localhost:3100
+  config:
+    grafanaExternalEndpoint: localhost:3000
+- name: jaeger-eu1
+  type: jaeger
+  internalEndpoint: jaeger:16686
+  externalEndpoint: localhost:16686
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, len(cfg.Sources))
+	testutil.Equals(t, "thanos", cfg.Sources[0].Name)
+	testutil.Equals(t, "jaeger-eu1", cfg.Sources[2].Name)
+
+	c, err := New(cfg, log.NewNopLogger())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, len(c.Sources()))
+	_, ok := c.Sources()[1].(LogsSource)
+	testutil.Assert(t, ok, "expected loki to be a logs source")
+
+	_, err = ParseConfig([]byte(`
+sources:
+- type: thanos
+- type: thanos
+`))
+	testutil.NotOk(t, err)
+
+	_, err = ParseConfig([]byte(`
+sources:
+- type: not-existing
+`))
+	testutil.NotOk(t, err)
+
+	_, err = ParseConfig([]byte(`
+sources:
+- type: thanos
+  matchers: '{cluster="eu1"'
+`))
+	testutil.NotOk(t, err)
+}
@@ -3,234 +3,1112 @@ package correlator
 import (
 	"context"
 	"fmt"
-	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/pkg/errors"
-	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
-	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/model/labels"
 )
 
 type Correlator struct {
-	cfg    Config
-	logger log.Logger
+	cfg     Config
+	logger  log.Logger
+	sources []Source
+	rules   []*correlationRule
+	// routes holds matchers routing correlations to the source, by source name.
+	routes map[string][]*labels.Matcher
+	// mappings holds label mappings of the source, by source name.
+	mappings map[string]labelMapping
+	// traceMapping translates trace root span labels to scope labels.
+	traceMapping labelMapping
+	// timeouts holds timeouts of single calls to the source, by source name.
+	timeouts map[string]time.Duration
 }
 
 func New(cfg Config, logger log.Logger) (*Correlator, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Wrap(err, "validate config")
+	}
+
+	c := &Correlator{
+		cfg:      cfg,
+		logger:   logger,
+		routes:   map[string][]*labels.Matcher{},
+		mappings: map[string]labelMapping{},
+		timeouts: map[string]time.Duration{},
+	}
+	for _, sc := range cfg.Sources {
+		s, err := NewSource(sc, logger)
+		if err != nil {
+			return nil, err
+		}
+		c.sources = append(c.sources, s)
+
+		ms, err := sc.matchers()
+		if err != nil {
+			return nil, errors.Wrapf(err, "source %v", sc.Name)
+		}
+		c.routes[sc.Name] = ms
+
+		lm, err := sc.labelMapping()
+		if err != nil {
+			return nil, errors.Wrapf(err, "source %v", sc.Name)
+		}
+		c.mappings[sc.Name] = lm
+		c.timeouts[sc.Name] = time.Duration(sc.Timeout)
+	}
+	tm, err := cfg.traceLabelMapping()
+	if err != nil {
+		return nil, errors.Wrap(err, "trace label mapping")
+	}
+	c.traceMapping = tm
+	for _, r := range cfg.Correlations {
+		cr, err := newCorrelationRule(r)
+		if err != nil {
+			return nil, err
+		}
+		c.rules = append(c.rules, cr)
+	}
+	return c, nil
+}
+
+// Sources returns all configured sources.
+func (c *Correlator) Sources() []Source {
+	return c.sources
+}
+
+// sourcesFor returns sources routed for the given labels. Source is routed if none of its matchers contradicts
+// labels, so all sources are returned for empty labels.
+func (c *Correlator) sourcesFor(lset model.LabelSet) []Source {
+	var ret []Source
+sourceLoop:
+	for _, s := range c.sources {
+		for _, m := range c.routes[s.Name()] {
+			v, ok := lset[model.LabelName(m.Name)]
+			if ok && !m.Matches(string(v)) {
+				continue sourceLoop
+			}
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// scopeFor returns scope with labels translated by the label mapping of the given source.
+func (c *Correlator) scopeFor(s Source, scope Scope) Scope {
+	lset, err := c.mappings[s.Name()].apply(scope.Labels)
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "failed to map labels", "source", s.Name(), "labels", scope.Labels, "err", err)
+	}
+	scope.Labels = lset
+	return scope
+}
+
+// sourceContext returns context for a single call to the source with the given name, limited by its timeout.
+func (c *Correlator) sourceContext(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	if t := c.timeouts[name]; t > 0 {
+		return context.WithTimeout(ctx, t)
+	}
+	return context.WithCancel(ctx)
+}
+
+// parallel calls f for every index in [0, n) concurrently and waits for all calls to return.
+func parallel(n int, f func(i int)) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
 
-	return &Correlator{
-		cfg:    cfg,
-		logger: logger,
-	}, nil
+// metricsSources returns metrics sources routed for the given labels.
+func (c *Correlator) metricsSources(lset model.LabelSet) []MetricsSource {
+	var ret []MetricsSource
+	for _, s := range c.sourcesFor(lset) {
+		if m, ok := s.(MetricsSource); ok {
+			ret = append(ret, m)
+		}
+	}
+	return ret
 }
 
+// CorrelationStatus tells if data linked by the correlation was verified to exist in the source.
+type CorrelationStatus string
+
+const (
+	// CorrelationVerified means the linked data exists.
+	CorrelationVerified CorrelationStatus = "verified"
+	// CorrelationEmpty means the link leads to no data.
+	CorrelationEmpty CorrelationStatus = "empty"
+	// CorrelationUnknown means the source can't verify the link or verification failed.
+	CorrelationUnknown CorrelationStatus = "unknown"
+)
+
 type Correlation struct {
-	Error       error `json:",omitempty"`
+	// Error is set if the correlation could not be fully produced, e.g. its verification failed.
+	Error       *Error `json:",omitempty"`
 	Description string
 	URL         string
+	// Source is a name of the source Correlation points to.
+	Source string
+	Status CorrelationStatus
+	// Evidence is linked data inlined in the correlation, if available.
+	Evidence *Evidence `json:",omitempty"`
 }
 
 type Input struct {
-	AlertName      string
+	AlertName string
+	// AlertMatchers optionally select firing instances of the alert by their labels.
+	AlertMatchers []*labels.Matcher
+	// AlertFingerprint optionally selects a single firing instance of the alert by the fingerprint of its
+	// labels, as shown by Alertmanager.
+	AlertFingerprint string
+	// AlertLabels optionally specify the alert instance directly, e.g. as received from Alertmanager webhook. It's
+	// correlated even if the alert no longer fires. AlertStartsAt is the time alert instance started firing.
+	AlertLabels    model.LabelSet
+	AlertStartsAt  time.Time
 	IgnoreExemplar bool
+
+	// Query starts the correlation from the given PromQL query in the Start and End time range, if AlertName is
+	// not set. End defaults to now, Start defaults to one hour before End.
+	Query      string
+	Start, End time.Time
+
+	// TraceID starts the correlation from the given trace, if neither AlertName nor Query is set.
+	TraceID string
+
+	// Tenant optionally limits the correlation to the data of the given tenant, passed to sources as configured
+	// by their Tenancy.
+	Tenant string
 }
 
-type Discovery string
+// DiscoveryKind tells what was discovered. Kinds are stable, so clients can branch on them.
+type DiscoveryKind string
+
+const (
+	// DiscoveryAlertFiring means the alert instance fires, Labels are its labels.
+	DiscoveryAlertFiring DiscoveryKind = "alert_firing"
+	// DiscoveryQueryScope describes the query being correlated, Labels are common to all its selectors.
+	DiscoveryQueryScope DiscoveryKind = "query_scope"
+	// DiscoveryMetricsSource means the query matches multiple metrics sources and only Source is used.
+	DiscoveryMetricsSource DiscoveryKind = "metrics_source"
+	// DiscoveryExemplarFound means exemplar with trace ID was found for the selector, Labels are labels of its series.
+	DiscoveryExemplarFound DiscoveryKind = "exemplar_found"
+	// DiscoveryTraceFound means the trace was fetched, Labels are resource attributes of its root span.
+	DiscoveryTraceFound DiscoveryKind = "trace_found"
+	// DiscoveryTraceError means the trace could not be fetched from any traces source.
+	DiscoveryTraceError DiscoveryKind = "trace_error"
+	// DiscoverySourceError means the source failed and some results might be missing.
+	DiscoverySourceError DiscoveryKind = "source_error"
+	// DiscoveryProfileHotspot means a single function takes the biggest part of the profile.
+	DiscoveryProfileHotspot DiscoveryKind = "profile_hotspot"
+)
+
+// DiscoverySeverity tells how much attention the discovery needs.
+type DiscoverySeverity string
+
+const (
+	DiscoverySeverityInfo    DiscoverySeverity = "info"
+	DiscoverySeverityWarning DiscoverySeverity = "warning"
+)
+
+// Keys of Discovery attributes.
+const (
+	AttributeTraceID     = "traceID"
+	AttributeQuery       = "query"
+	AttributeStart       = "start"
+	AttributeEnd         = "end"
+	AttributeService     = "service"
+	AttributeOperation   = "operation"
+	AttributeSpans       = "spans"
+	AttributeError       = "error"
+	AttributeFunction    = "function"
+	AttributeFlatPercent = "flatPercent"
+	AttributeUnit        = "unit"
+	// AttributeIgnoredSources is a comma separated list of source names.
+	AttributeIgnoredSources = "ignoredSources"
+)
+
+// Discovery is something learned during the correlation, described both for machines and humans.
+type Discovery struct {
+	Kind     DiscoveryKind
+	Severity DiscoverySeverity
+	// Message is the discovery rendered for humans.
+	Message string
+	// Labels the discovery relates to, see DiscoveryKind for their meaning.
+	Labels model.LabelSet `json:",omitempty"`
+	// Attributes hold other details of the discovery by Attribute* keys, e.g. trace ID.
+	Attributes map[string]string `json:",omitempty"`
+	// Source is a name of the source the discovery came from, if any.
+	Source string `json:",omitempty"`
+}
+
+func (d Discovery) String() string {
+	return d.Message
+}
+
+// Result is a single result of the correlation. Only one of Discovery or Correlation is set.
+type Result struct {
+	// Index is the position of the result in the stable order of results, independent of how long sources took
+	// to answer. Results are passed as soon as they are produced, so clients that need stable order should sort
+	// them by Index. Results produced together (e.g. discovery from correlation evidence followed by the
+	// correlation) share the Index and are passed in order.
+	Index int
+	// Alert is set to the labels of the alert instance if the result relates to it.
+	Alert model.LabelSet `json:",omitempty"`
+	// Selector is set if the result relates to a single series selector from the alert expression.
+	Selector    string       `json:",omitempty"`
+	Discovery   *Discovery   `json:",omitempty"`
+	Correlation *Correlation `json:",omitempty"`
+}
+
+// Response is a complete correlation response.
+type Response struct {
+	Results
+	// Alerts holds results grouped by firing alert instance.
+	Alerts []AlertResponse `json:",omitempty"`
+}
+
+// Results holds discoveries and correlations, grouped by series selector if they relate to one.
+type Results struct {
+	Discoveries  []Discovery
+	Correlations []Correlation
+	// Selectors holds results grouped by series selector from the alert expression.
+	Selectors []SelectorResponse `json:",omitempty"`
+}
+
+// AlertResponse holds results related to a single firing alert instance.
+type AlertResponse struct {
+	// Fingerprint is the fingerprint of the alert labels, as shown by Alertmanager.
+	Fingerprint string
+	Labels      model.LabelSet
+	Results
+}
+
+// SelectorResponse holds results related to a single series selector.
+type SelectorResponse struct {
+	Selector     string
+	Discoveries  []Discovery   `json:",omitempty"`
+	Correlations []Correlation `json:",omitempty"`
+}
+
+// Add adds result to the response, grouping it by alert instance and selector.
+func (r *Response) Add(res Result) {
+	if res.Alert == nil {
+		r.Results.add(res)
+		return
+	}
+
+	fp := res.Alert.Fingerprint().String()
+	for i := range r.Alerts {
+		if r.Alerts[i].Fingerprint == fp {
+			r.Alerts[i].add(res)
+			return
+		}
+	}
+	r.Alerts = append(r.Alerts, AlertResponse{Fingerprint: fp, Labels: res.Alert})
+	r.Alerts[len(r.Alerts)-1].add(res)
+}
+
+func (r *Results) add(res Result) {
+	d, corr := &r.Discoveries, &r.Correlations
+	if res.Selector != "" {
+		i := 0
+		for ; i < len(r.Selectors); i++ {
+			if r.Selectors[i].Selector == res.Selector {
+				break
+			}
+		}
+		if i == len(r.Selectors) {
+			r.Selectors = append(r.Selectors, SelectorResponse{Selector: res.Selector})
+		}
+		d, corr = &r.Selectors[i].Discoveries, &r.Selectors[i].Correlations
+	}
+
+	if res.Discovery != nil {
+		*d = append(*d, *res.Discovery)
+	}
+	if res.Correlation != nil {
+		*corr = append(*corr, *res.Correlation)
+	}
+}
 
 // Correlate provides correlations from the best effort input.
 // NOTE: ARTIFICIAL INTELLIGENCE - USE WITH CARE!
-// TODO(bwplotka): Make it a streaming response.
-// TODO(bwplotka): Compose it better, it's currently a too long function with hardcoded elements for demo purposes.
-func (c *Correlator) Correlate(ctx context.Context, input Input) (d []Discovery, corr []Correlation, _ error) {
+func (c *Correlator) Correlate(ctx context.Context, input Input) (Response, error) {
+	var results []Result
+	if err := c.CorrelateStream(ctx, input, func(res Result) { results = append(results, res) }); err != nil {
+		return Response{}, err
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+
+	resp := Response{}
+	for _, res := range results {
+		resp.Add(res)
+	}
+	return resp, nil
+}
+
+// CorrelateStream is like Correlate, but it passes each result to fn as soon as it is produced. Sources are queried
+// concurrently, each call limited by the source timeout, but fn is called for one result at a time. Slow sources do
+// not hold back results of other ones; Result.Index gives the stable order of results instead. Failures of single correlations are recorded in their Error and do not fail the whole correlation.
+func (c *Correlator) CorrelateStream(ctx context.Context, input Input, fn func(Result)) error {
 	level.Debug(c.logger).Log("msg", "correlating from Input", "input", fmt.Sprintf("%v", input))
+	ctx = WithTenant(ctx, input.Tenant)
 
-	if input.AlertName == "" {
-		return nil, nil, errors.New("not enough information")
+	emit := c.newEmitter(ctx, fn)
+	// Correlations are verified in the background, make sure fn is not called after return.
+	defer emit.wait()
+
+	switch {
+	case input.AlertName != "":
+		return c.correlateAlerts(ctx, emit, input)
+	case input.Query != "":
+		return c.correlateQueryInput(ctx, emit, input)
+	case input.TraceID != "":
+		return c.correlateTrace(ctx, emit, input)
 	}
-	thanosClient, err := api.NewClient(api.Config{
-		Address: "http://" + c.cfg.Sources.Thanos.InternalEndpoint,
-	})
+	return errors.Wrap(ErrInvalidInput, "alert name, query or trace ID is required")
+}
+
+// correlateAlerts emits results for all firing instances of the alert selected by input. The same alert can be
+// defined in many metrics sources (e.g. one per region), its instances are looked up in all of them.
+func (c *Correlator) correlateAlerts(ctx context.Context, emit *emitter, input Input) error {
+	// If alert instance is given directly, use only sources routed for its labels.
+	found, err := c.findAlertingRules(ctx, emit, c.metricsSources(input.AlertLabels), input.AlertName)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "new Thanos HTTP client")
+		return err
 	}
 
-	thanosAPI := v1.NewAPI(thanosClient)
-	rules, err := thanosAPI.Rules(ctx)
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "rules")
-	}
-
-	var alert *v1.Alert
-	var alertRule v1.AlertingRule
-
-groupLoop:
-	for _, g := range rules.Groups {
-		for _, r := range g.Rules {
-			switch v := r.(type) {
-			case v1.AlertingRule:
-				if v.Name == input.AlertName {
-					if len(v.Alerts) == 0 {
-						return nil, nil, errors.Errorf("requested alert no longer fires, alertname: %v", input.AlertName)
-					}
-					alert = v.Alerts[0]
-					alertRule = v
-					break groupLoop
-				}
+	var selectErr error
+	correlated := false
+	for _, alertRule := range found {
+		alerts, err := selectAlerts(alertRule.rule, input)
+		if err != nil {
+			selectErr = err
+			continue
+		}
+
+		selectors, err := extractSelectors(alertRule.rule.Query)
+		if err != nil {
+			return err
+		}
+		for _, alert := range alerts {
+			level.Debug(c.logger).Log("msg", "found firing alert", "alert", alert.Labels, "source", alertRule.source.Name())
+			if err := c.correlateAlert(ctx, emit.forAlert(alert.Labels), input, alertRule.source, alertRule.rule, alert, selectors); err != nil {
+				return err
 			}
 		}
+		correlated = true
+		if input.AlertLabels != nil {
+			// Alert instance given directly was correlated.
+			break
+		}
 	}
+	if !correlated {
+		return selectErr
+	}
+	return nil
+}
 
-	level.Debug(c.logger).Log("msg", "found firing alert", "alert", alert.Labels)
-
-	d = append(d, Discovery(fmt.Sprintf("Alert is indeed firing... 😱 Its labels: %v", alert.Labels)))
+// correlateAlert emits results for a single firing alert instance.
+func (c *Correlator) correlateAlert(
+	ctx context.Context,
+	emit *emitter,
+	input Input,
+	metrics MetricsSource,
+	alertRule v1.AlertingRule,
+	alert *v1.Alert,
+	selectors []selector,
+) error {
+	emit.discovery(Discovery{
+		Kind:     DiscoveryAlertFiring,
+		Severity: DiscoverySeverityWarning,
+		Message:  fmt.Sprintf("Alert is indeed firing... 😱 Its labels: %v", alert.Labels),
+		Labels:   alert.Labels,
+		Source:   metrics.Name(),
+	})
 
+	// Labels identifying series the alert was produced from.
 	lbl := alert.Labels.Clone()
+	delete(lbl, model.AlertNameLabel)
 	for predef := range alertRule.Labels {
 		delete(lbl, predef)
 	}
 
-	expr, err := parser.ParseExpr(alertRule.Query)
+	window, err := c.alertWindow(alertRule, alert, time.Now())
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
-	selectors := parser.ExtractSelectors(expr)
+	window.Labels = alert.Labels
+	window.Tenant = input.Tenant
+	c.correlateQuery(ctx, emit, input, metrics, alertValueQuery(alertRule.Query), selectors, window, lbl)
+	return nil
+}
 
-	// TODO(bwplotka): Support more than one.
-	if len(selectors) == 0 {
-		return nil, nil, errors.Errorf("can find selectors for %v", alertRule.Query)
+// correlateQueryInput emits results for the PromQL query and time range from input.
+func (c *Correlator) correlateQueryInput(ctx context.Context, emit *emitter, input Input) error {
+	selectors, err := extractSelectors(input.Query)
+	if err != nil {
+		if errors.Is(err, ErrNoSelectors) {
+			return err
+		}
+		return errors.Wrapf(ErrInvalidInput, "%v", err)
 	}
-	firstMatchers := selectors[0]
 
-	var exampleRequestID string // Or traceID, same thing.
-	var exRes v1.ExemplarQueryResult
-	if !input.IgnoreExemplar {
-		// Get time range from expression.
-		res, err := thanosAPI.QueryExemplars(ctx, alertRule.Query, time.Now().Add(-5*time.Minute), time.Now())
-		if err != nil {
-			return nil, nil, errors.Wrap(err, "exemplars")
-		}
-
-		if len(res) == 0 {
-			level.Error(c.logger).Log("msg", "no exemplars found for series in question", "query", alertRule.Query)
-		} else {
-			level.Debug(c.logger).Log("msg", "found exemplars, taking first", "len", len(res), "query", alertRule.Query)
-
-			for _, r := range res {
-				match := true
-				for _, m := range firstMatchers {
-					l, ok := r.SeriesLabels[model.LabelName(m.Name)]
-					if !ok {
-						continue
-					}
-					if !m.Matches(string(l)) {
-						match = false
-						break
-					}
-				}
-				if !match {
-					continue
-				}
-
-				exRes = r
-				break
-			}
+	scope := Scope{Start: input.Start, End: input.End, Tenant: input.Tenant}
+	if scope.End.IsZero() {
+		scope.End = time.Now()
+	}
+	if scope.Start.IsZero() {
+		scope.Start = scope.End.Add(-1 * time.Hour)
+	}
+	if !scope.Start.Before(scope.End) {
+		return errors.Wrapf(ErrInvalidInput, "start %v has to be before end %v", scope.Start, scope.End)
+	}
+	scope.Labels = commonLabels(selectors)
+
+	metrics := c.metricsSources(scope.Labels)
+	if len(metrics) == 0 {
+		return errors.Wrapf(ErrInvalidInput, "no metrics source configured for labels %v", scope.Labels)
+	}
+	if len(metrics) > 1 {
+		ignored := make([]string, 0, len(metrics)-1)
+		for _, m := range metrics[1:] {
+			ignored = append(ignored, m.Name())
+		}
+		emit.discovery(Discovery{
+			Kind:     DiscoveryMetricsSource,
+			Severity: DiscoverySeverityInfo,
+			Message: fmt.Sprintf("Query matches %d metrics sources, correlating it with the first one: %v. Ignored sources: %v. Use source matchers to pick another one.",
+				len(metrics), metrics[0].Name(), strings.Join(ignored, ", ")),
+			Labels:     scope.Labels,
+			Attributes: map[string]string{AttributeIgnoredSources: strings.Join(ignored, ",")},
+			Source:     metrics[0].Name(),
+		})
+	}
 
-			if len(exRes.Exemplars) == 0 {
-				level.Error(c.logger).Log("msg", "no exemplars matching ):", "matchers", fmt.Sprintf("%v", firstMatchers))
-			} else {
-				level.Debug(c.logger).Log("msg", "found exemplar", "series", exRes.SeriesLabels, "labels", exRes.Exemplars[0].Labels)
+	start, end := scope.Start.UTC().Format(time.RFC3339), scope.End.UTC().Format(time.RFC3339)
+	emit.discovery(Discovery{
+		Kind:     DiscoveryQueryScope,
+		Severity: DiscoverySeverityInfo,
+		Message: fmt.Sprintf("Correlating query %v from %v to %v. Labels common to all its selectors: %v",
+			input.Query, start, end, scope.Labels),
+		Labels:     scope.Labels,
+		Attributes: map[string]string{AttributeQuery: input.Query, AttributeStart: start, AttributeEnd: end},
+		Source:     metrics[0].Name(),
+	})
+	c.correlateQuery(ctx, emit, input, metrics[0], input.Query, selectors, scope, scope.Labels)
+	return nil
+}
+
+// correlateQuery emits results for the PromQL query with given selectors in the scope. Scope labels are used for
+// correlations not related to a single selector, series labels filter series to look for exemplars in. Exemplars
+// for all selectors are looked up concurrently, selectors without them are correlated by labels and time.
+func (c *Correlator) correlateQuery(
+	ctx context.Context,
+	emit *emitter,
+	input Input,
+	metrics MetricsSource,
+	query string,
+	selectors []selector,
+	scope Scope,
+	seriesLabels model.LabelSet,
+) {
+	data := TemplateData{
+		Labels: labelsMap(scope.Labels),
+		Start:  scope.Start,
+		End:    scope.End,
+		Tenant: scope.Tenant,
+	}
 
-				// TODO(bwplotka): Un-hardcode traceID key.
-				exampleRequestID = string(exRes.Exemplars[0].Labels["traceID"])
-				if exampleRequestID == "" {
-					level.Error(c.logger).Log("msg", "no traceID key in labels")
-				} else {
-					d = append(d, Discovery(fmt.Sprintf("We found example Trace/Request ID for you! %v 🤗", exampleRequestID)))
+	exemplars := make([]Scope, len(selectors))
+	exemplarErrs := make([]error, len(selectors))
+	if !input.IgnoreExemplar {
+		parallel(len(selectors), func(i int) {
+			sctx, cancel := c.sourceContext(ctx, metrics.Name())
+			defer cancel()
+			exemplars[i], exemplarErrs[i] = c.findExemplar(sctx, metrics, selectors[i], seriesLabels, scope)
+		})
+	}
 
-				}
+	exemplarFound := false
+	for i, sel := range selectors {
+		semit := emit.forSelector(sel.str)
+		corr := Correlation{
+			Description: fmt.Sprintf("Metric View for the selector and the query [%s]", metrics.Name()),
+			URL:         metrics.MetricsURL(scope, sel.viewQuery(), query),
+			Source:      metrics.Name(),
+		}
+		if err := exemplarErrs[i]; err != nil {
+			// Metric view is still valid, the selector is correlated as if it had no exemplars.
+			if !errors.Is(err, ErrNoExemplars) {
+				level.Warn(c.logger).Log("msg", "failed to find exemplar", "source", metrics.Name(), "selector", sel.str, "err", err)
 			}
+			corr.Error = NewError(err)
 		}
-	}
+		semit.correlation(corr, nil)
 
-	// Thanos Metrics view.
+		ex := exemplars[i]
+		if ex.TraceID != "" {
+			exemplarFound = true
+			semit.discovery(Discovery{
+				Kind:       DiscoveryExemplarFound,
+				Severity:   DiscoverySeverityInfo,
+				Message:    fmt.Sprintf("We found example Trace/Request ID for you! %v 🤗", ex.TraceID),
+				Labels:     ex.Labels,
+				Attributes: map[string]string{AttributeTraceID: ex.TraceID},
+				Source:     metrics.Name(),
+			})
+			c.exemplarCorrelations(semit, ex)
+		}
 
-	// TODO(bwplotka): Create lib for building query?
-	strMatchers := make([]string, 0, 10)
-	for _, matcher := range selectors[0] {
-		strMatchers = append(strMatchers, matcher.String())
+		sdata := data
+		sdata.Matchers = sel.matchers
+		sdata.TraceID = ex.TraceID
+		semit.rules(sdata)
 	}
 
-	query := fmt.Sprintf("{%s}", strings.Join(strMatchers, ","))
-	for _, matcher := range selectors[0] {
-		if matcher.Name == "__name__" {
-			if strings.HasSuffix(matcher.Value, "_total") {
-				query = "rate(" + query + "[1m])"
+	if exemplarFound {
+		for _, s := range c.sourcesFor(scope.Labels) {
+			if p, ok := s.(ProfilesSource); ok {
+				sc := c.scopeFor(s, scope)
+				emit.correlation(Correlation{
+					Description: fmt.Sprintf("Profiles View for the same container and time [%s]", s.Name()),
+					URL:         p.ProfilesURL(sc),
+					Source:      s.Name(),
+				}, c.verifyProfiles(s, sc))
 			}
 		}
+		return
+	}
+	c.alertCorrelations(emit, scope)
+}
+
+// correlateTrace emits results for the trace with the given ID. Trace is fetched from the first traces source
+// that has it, to find services it went through and its time window.
+func (c *Correlator) correlateTrace(ctx context.Context, emit *emitter, input Input) error {
+	traceID, err := inputTraceID(input.TraceID)
+	if err != nil {
+		return errors.Wrapf(ErrInvalidInput, "%v", err)
 	}
 
-	corr = append(corr, Correlation{
-		Description: "Metric View for the source of Alert [Thanos]",
-		URL: "http://" + c.cfg.Sources.Thanos.ExternalEndpoint +
-			`/graph?g0.expr=` + url.QueryEscape(query) +
-			`&g0.tab=0&g0.stacked=0&g0.range_input=15m&g0.max_source_resolution=0s&` +
-			`g1.expr=` + url.QueryEscape(strings.TrimSuffix(alertRule.Query, " > 0.3")) +
-			`&g1.tab=0&g1.stacked=0&g1.range_input=15m&g1.max_source_resolution=0s&`,
+	var fetchers []TraceFetcher
+	for _, s := range c.sources {
+		if f, ok := s.(TraceFetcher); ok {
+			fetchers = append(fetchers, f)
+		}
+	}
+	traces := make([]*Trace, len(fetchers))
+	parallel(len(fetchers), func(i int) {
+		sctx, cancel := c.sourceContext(ctx, fetchers[i].Name())
+		defer cancel()
+		t, err := fetchers[i].Trace(sctx, traceID)
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "failed to fetch trace", "source", fetchers[i].Name(), "traceID", traceID, "err", err)
+			return
+		}
+		traces[i] = t
 	})
 
-	// Exemplars path.
-	if exampleRequestID != "" {
-		corr = append(corr, Correlation{
-			Description: "Log View connected to the Exemplar [Loki via Grafana]",
-			// TODO(bwplotka): yolo - unhardcode!
-			URL: "http://" + c.cfg.Sources.Loki.UISource.ExternalEndpoint +
-				`/explore?orgId=1&left=%5B%22now-1h%22,%22now%22,%22Logging%22,%7B%22refId%22:%22A%22,%22expr%22:%22%7Bjobs%3D%5C%22` +
-				string(exRes.SeriesLabels["job"]) + `%5C%22%7D%20%7C%3D%20%5C%22` + exampleRequestID + `%5C%22%5Cn%22%7D%5D`,
-		})
-		corr = append(corr, Correlation{
-			Description: "Trace View connected to the Exemplar [Jaeger]",
-			URL:         "http://" + c.cfg.Sources.Jaeger.ExternalEndpoint + "/trace/" + exampleRequestID,
-		})
-		// TODO(bwplotka) Check if Parca is configured!
-		// TODO(bwplotka): Parse time!
-		corr = append(corr, Correlation{
-			Description: "Profiles View for the same container and time [Parca]",
-			URL: "http://" + c.cfg.Sources.Parca.ExternalEndpoint +
-				`/?currentProfileView=icicle&expression_a=process_cpu%3Acpu%3Ananoseconds%3Acpu%3Ananoseconds%3Adelta%7B` +
-				`%20job%3D%22` + "e2e-correlation-" + string(alert.Labels["job"]) + `%3A8080%22%7D&merge_a=true&time_selection_a=relative:hour|1`,
+	// Prefer sources in the configured order.
+	var (
+		trace       *Trace
+		traceSource string
+	)
+	for i, t := range traces {
+		if t != nil && len(t.Spans) > 0 {
+			trace, traceSource = t, fetchers[i].Name()
+			break
+		}
+	}
+
+	now := time.Now()
+	scope := Scope{TraceID: traceID, Start: now.Add(-1 * time.Hour), End: now, Tenant: input.Tenant}
+	data := TemplateData{Labels: map[string]string{}, TraceID: traceID, Tenant: input.Tenant}
+	if trace == nil {
+		emit.discovery(Discovery{
+			Kind:       DiscoveryTraceError,
+			Severity:   DiscoverySeverityWarning,
+			Message:    fmt.Sprintf("Could not fetch trace %v from any traces source, using last hour as the time window 🤔", traceID),
+			Attributes: map[string]string{AttributeTraceID: traceID},
 		})
-		// TODO(bwplotka): Parca storage not always is able to find trace label. Some sampling is happening?
-		corr = append(corr, Correlation{
-			Description: "Experimental: Profiles View connected to the Exemplar [Parca]",
-			URL: "http://" + c.cfg.Sources.Parca.ExternalEndpoint +
-				`/?currentProfileView=icicle&expression_a=process_cpu%3Acpu%3Ananoseconds%3Acpu%3Ananoseconds%3Adelta%7Bprofile_label_trace_id%3D%22` +
-				exampleRequestID + `%22%2C%20job%3D%22` + "e2e-correlation-" + string(exRes.SeriesLabels["job"]) + `%3A8080%22%7D&merge_a=true&time_selection_a=relative:hour|1`,
+	} else {
+		root := trace.Root()
+		for k, v := range root.Resource {
+			data.Labels[k] = v
+		}
+		data.Labels["service"] = root.Service
+		scope.Labels, err = c.traceMapping.apply(labelSet(data.Labels))
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "failed to map trace labels", "labels", fmt.Sprintf("%v", data.Labels), "err", err)
+		}
+		scope.Start = trace.Start().Add(-time.Duration(c.cfg.TimeWindowPadding))
+		scope.End = trace.End().Add(time.Duration(c.cfg.TimeWindowPadding))
+
+		start, end := trace.Start().UTC().Format(time.RFC3339), trace.End().UTC().Format(time.RFC3339)
+		emit.discovery(Discovery{
+			Kind:     DiscoveryTraceFound,
+			Severity: DiscoverySeverityInfo,
+			Message: fmt.Sprintf(
+				"Trace found! It started in service %v with %q operation and has %d spans from %v to %v. Resource attributes: %v 🤗",
+				root.Service, root.OperationName, len(trace.Spans), start, end, root.Resource,
+			),
+			Labels: labelSet(root.Resource),
+			Attributes: map[string]string{
+				AttributeTraceID:   traceID,
+				AttributeService:   root.Service,
+				AttributeOperation: root.OperationName,
+				AttributeSpans:     strconv.Itoa(len(trace.Spans)),
+				AttributeStart:     start,
+				AttributeEnd:       end,
+			},
+			Source: traceSource,
 		})
+	}
+	data.Start, data.End = scope.Start, scope.End
 
-		return d, corr, nil
+	for _, s := range c.sourcesFor(scope.Labels) {
+		if t, ok := s.(TracesSource); ok {
+			sc := c.scopeFor(s, scope)
+			emit.correlation(Correlation{
+				Description: fmt.Sprintf("Trace View [%s]", s.Name()),
+				URL:         t.TraceURL(sc),
+				Source:      s.Name(),
+			}, c.verifyTrace(s, sc))
+		}
 	}
+	for _, s := range c.sourcesFor(scope.Labels) {
+		if l, ok := s.(LogsSource); ok {
+			sc := c.scopeFor(s, scope)
+			emit.correlation(Correlation{
+				Description: fmt.Sprintf("Log View connected to the Trace [%s]", s.Name()),
+				URL:         l.LogsURL(sc),
+				Source:      s.Name(),
+			}, c.verifyLogs(s, sc))
+		}
+	}
+	if len(scope.Labels) > 0 {
+		for _, s := range c.sourcesFor(scope.Labels) {
+			if m, ok := s.(MetricsSource); ok {
+				sc := c.scopeFor(s, scope)
+				emit.correlation(Correlation{
+					Description: fmt.Sprintf("Metric View with rate, errors and duration of requests of the Trace service [%s]", s.Name()),
+					URL:         m.MetricsURL(sc, m.REDQueries(sc.Labels)...),
+					Source:      s.Name(),
+				}, nil)
+			}
+		}
+	}
+	for _, s := range c.sourcesFor(scope.Labels) {
+		if p, ok := s.(ProfilesSource); ok {
+			sc := c.scopeFor(s, scope)
+			emit.correlation(Correlation{
+				Description: fmt.Sprintf("Experimental: Profiles View connected to the Trace [%s]", s.Name()),
+				URL:         p.ProfilesURL(sc),
+				Source:      s.Name(),
+			}, c.verifyProfiles(s, sc))
+		}
+	}
+	emit.rules(data)
+	return nil
+}
 
-	corr = append(corr, Correlation{
-		Description: "Log View for the same container and time [Loki via Grafana]",
-		// TODO(bwplotka): yolo - unhardcode!
-		URL: "http://" + c.cfg.Sources.Loki.UISource.ExternalEndpoint +
-			`/explore?orgId=1&left=%5B%22now-1h%22,%22now%22,%22Logging%22,%7B%22refId%22:%22A%22,%22expr%22:%22%7Bjobs%3D%5C%22` + string(alert.Labels["job"]) + `%5C%22%7D%22%7D%5D`,
-	})
+// alertWindow returns the time window of the alert correlation. It starts when the alert became active, minus
+// the longest range used in the alert expression (data before that could not influence the alert) and
+// configured padding. It ends now.
+func (c *Correlator) alertWindow(alertRule v1.AlertingRule, alert *v1.Alert, now time.Time) (Scope, error) {
+	r, err := maxRange(alertRule.Query)
+	if err != nil {
+		return Scope{}, err
+	}
 
-	corr = append(corr, Correlation{
-		Description: "Trace View for the same container and time [Jaeger]",
-		URL:         "http://" + c.cfg.Sources.Jaeger.ExternalEndpoint + "/search?limit=20&lookback=1h&maxDuration&minDuration&service=demo%3Aping",
-	})
-	corr = append(corr, Correlation{
-		Description: "Profiles View for the same container and time [Parca]",
-		URL: "http://" + c.cfg.Sources.Parca.ExternalEndpoint +
-			`/?currentProfileView=icicle&expression_a=process_cpu%3Acpu%3Ananoseconds%3Acpu%3Ananoseconds%3Adelta%7B` +
-			`%20job%3D%22` + "e2e-correlation-" + string(alert.Labels["job"]) + `%3A8080%22%7D&merge_a=true&time_selection_a=relative:hour|1`,
+	start := now
+	if !alert.ActiveAt.IsZero() && alert.ActiveAt.Before(now) {
+		start = alert.ActiveAt
+	}
+	return Scope{Start: start.Add(-r - time.Duration(c.cfg.TimeWindowPadding)), End: now}, nil
+}
+
+// sourceRule is an alerting rule together with the metrics source it was found in.
+type sourceRule struct {
+	source MetricsSource
+	rule   v1.AlertingRule
+}
+
+// findAlertingRules looks for the alerting rule with the given name in the given metrics sources concurrently.
+// Sources that failed are reported as discoveries, unless the rule was not found in any other source.
+func (c *Correlator) findAlertingRules(ctx context.Context, emit *emitter, metrics []MetricsSource, alertName string) ([]sourceRule, error) {
+	rules := make([][]v1.AlertingRule, len(metrics))
+	errs := make([]error, len(metrics))
+	parallel(len(metrics), func(i int) {
+		sctx, cancel := c.sourceContext(ctx, metrics[i].Name())
+		defer cancel()
+		rules[i], errs[i] = metrics[i].AlertingRules(sctx)
 	})
-	return d, corr, nil
+
+	var (
+		ret     []sourceRule
+		lastErr error
+	)
+	for i, m := range metrics {
+		if errs[i] != nil {
+			lastErr = &SourceError{Source: m.Name(), Err: errs[i]}
+			continue
+		}
+		for _, r := range rules[i] {
+			if r.Name == alertName {
+				ret = append(ret, sourceRule{source: m, rule: r})
+				break
+			}
+		}
+	}
+	if len(ret) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, errors.Wrapf(ErrAlertNotFound, "alertname %v", alertName)
+	}
+	for i, m := range metrics {
+		if errs[i] != nil {
+			level.Warn(c.logger).Log("msg", "failed to get alerting rules", "source", m.Name(), "err", errs[i])
+			emit.discovery(Discovery{
+				Kind:       DiscoverySourceError,
+				Severity:   DiscoverySeverityWarning,
+				Message:    fmt.Sprintf("Could not look for the alert in %v source, its instances might be missing: %v 😵", m.Name(), errs[i]),
+				Attributes: map[string]string{AttributeError: errs[i].Error()},
+				Source:     m.Name(),
+			})
+		}
+	}
+	return ret, nil
+}
+
+// selectAlerts returns firing instances of the alert selected by input. All firing instances are returned if
+// input does not select any. Alert instance given directly in input is returned as it is.
+func selectAlerts(r v1.AlertingRule, input Input) ([]*v1.Alert, error) {
+	if input.AlertLabels != nil {
+		return []*v1.Alert{{Labels: input.AlertLabels, ActiveAt: input.AlertStartsAt, State: v1.AlertStateFiring}}, nil
+	}
+
+	var firing []*v1.Alert
+	for _, a := range r.Alerts {
+		if a.State == v1.AlertStateFiring {
+			firing = append(firing, a)
+		}
+	}
+	if len(firing) == 0 {
+		return nil, errors.Wrapf(ErrAlertNotFiring, "alertname %v", input.AlertName)
+	}
+
+	var ret []*v1.Alert
+alertLoop:
+	for _, a := range firing {
+		if input.AlertFingerprint != "" && a.Labels.Fingerprint().String() != input.AlertFingerprint {
+			continue
+		}
+		for _, m := range input.AlertMatchers {
+			if !m.Matches(string(a.Labels[model.LabelName(m.Name)])) {
+				continue alertLoop
+			}
+		}
+		ret = append(ret, a)
+	}
+	if len(ret) == 0 {
+		return nil, errors.Wrapf(ErrAlertNotFiring, "none of %d firing instances of alert %v match requested fingerprint %q and matchers %v", len(firing), input.AlertName, input.AlertFingerprint, input.AlertMatchers)
+	}
+	return ret, nil
+}
+
+// findExemplar returns the scope of the latest exemplar with valid trace ID for series matching selector and
+// given labels within the window. ErrNoExemplars is returned if nothing was found.
+func (c *Correlator) findExemplar(ctx context.Context, metrics MetricsSource, sel selector, lbl model.LabelSet, window Scope) (Scope, error) {
+	res, err := metrics.Exemplars(ctx, sel.str, window.Start, window.End)
+	if err != nil {
+		return Scope{}, &SourceError{Source: metrics.Name(), Err: err}
+	}
+	if len(res) == 0 {
+		level.Debug(c.logger).Log("msg", "no exemplars found for series in question", "selector", sel.str)
+		return Scope{}, errors.Wrapf(ErrNoExemplars, "selector %v", sel.str)
+	}
+	level.Debug(c.logger).Log("msg", "found exemplars, taking latest", "len", len(res), "selector", sel.str)
+
+	for _, r := range res {
+		if !labelsConsistent(r.SeriesLabels, lbl) {
+			continue
+		}
+		// Exemplars are sorted by time, latest are the most relevant to the firing alert.
+		for i := len(r.Exemplars) - 1; i >= 0; i-- {
+			e := r.Exemplars[i]
+			traceID, err := metrics.TraceID(e.Labels)
+			if err != nil {
+				level.Warn(c.logger).Log("msg", "invalid trace ID in exemplar", "series", r.SeriesLabels, "labels", e.Labels, "err", err)
+				continue
+			}
+			if traceID == "" {
+				continue
+			}
+			level.Debug(c.logger).Log("msg", "found exemplar", "series", r.SeriesLabels, "labels", e.Labels)
+			ex := window
+			ex.Labels = r.SeriesLabels
+			ex.TraceID = traceID
+			return ex, nil
+		}
+	}
+	level.Debug(c.logger).Log("msg", "no exemplars with trace ID matching ):", "selector", sel.str, "labels", lbl)
+	return Scope{}, errors.Wrapf(ErrNoExemplars, "selector %v and labels %v", sel.str, lbl)
+}
+
+// labelsConsistent returns true if series labels do not contradict given labels.
+func labelsConsistent(series, lbl model.LabelSet) bool {
+	for n, v := range lbl {
+		if sv, ok := series[n]; ok && sv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// exemplarCorrelations emits correlations connected to the exemplar.
+func (c *Correlator) exemplarCorrelations(emit *emitter, ex Scope) {
+	for _, s := range c.sourcesFor(ex.Labels) {
+		if l, ok := s.(LogsSource); ok {
+			sc := c.scopeFor(s, ex)
+			emit.correlation(Correlation{
+				Description: fmt.Sprintf("Log View connected to the Exemplar [%s]", s.Name()),
+				URL:         l.LogsURL(sc),
+				Source:      s.Name(),
+			}, c.verifyLogs(s, sc))
+		}
+	}
+	for _, s := range c.sourcesFor(ex.Labels) {
+		if t, ok := s.(TracesSource); ok {
+			sc := c.scopeFor(s, ex)
+			emit.correlation(Correlation{
+				Description: fmt.Sprintf("Trace View connected to the Exemplar [%s]", s.Name()),
+				URL:         t.TraceURL(sc),
+				Source:      s.Name(),
+			}, c.verifyTrace(s, sc))
+		}
+	}
+	for _, s := range c.sourcesFor(ex.Labels) {
+		if p, ok := s.(ProfilesSource); ok {
+			sc := c.scopeFor(s, ex)
+			// TODO(bwplotka): Parca storage not always is able to find trace label. Some sampling is happening?
+			emit.correlation(Correlation{
+				Description: fmt.Sprintf("Experimental: Profiles View connected to the Exemplar [%s]", s.Name()),
+				URL:         p.ProfilesURL(sc),
+				Source:      s.Name(),
+			}, c.verifyProfiles(s, sc))
+		}
+	}
+}
+
+// alertCorrelations emits correlations for the same labels and time as alert, used when no exemplar was found.
+func (c *Correlator) alertCorrelations(emit *emitter, scope Scope) {
+	for _, s := range c.sourcesFor(scope.Labels) {
+		if l, ok := s.(LogsSource); ok {
+			sc := c.scopeFor(s, scope)
+			emit.correlation(Correlation{
+				Description: fmt.Sprintf("Log View for the same container and time [%s]", s.Name()),
+				URL:         l.LogsURL(sc),
+				Source:      s.Name(),
+			}, c.verifyLogs(s, sc))
+		}
+	}
+	for _, s := range c.sourcesFor(scope.Labels) {
+		if t, ok := s.(TracesSource); ok {
+			sc := c.scopeFor(s, scope)
+			emit.correlation(Correlation{
+				Description: fmt.Sprintf("Trace View for the same container and time [%s]", s.Name()),
+				URL:         t.TracesSearchURL(sc),
+				Source:      s.Name(),
+			}, c.verifyTraces(s, sc))
+		}
+	}
+	for _, s := range c.sourcesFor(scope.Labels) {
+		if p, ok := s.(ProfilesSource); ok {
+			sc := c.scopeFor(s, scope)
+			emit.correlation(Correlation{
+				Description: fmt.Sprintf("Profiles View for the same container and time [%s]", s.Name()),
+				URL:         p.ProfilesURL(sc),
+				Source:      s.Name(),
+			}, c.verifyProfiles(s, sc))
+		}
+	}
+}
+
+// emitter passes results to the callback. It skips built-in correlations of sources targeted by user
+// defined correlation rules with Replace set, as those are replaced by correlations produced by the rules.
+// Correlations are verified concurrently and results are passed to the callback one at a time, as soon as they are
+// done, with Index set to the order they were emitted in.
+type emitter struct {
+	c        *Correlator
+	ctx      context.Context
+	q        *resultQueue
+	alert    model.LabelSet
+	selector string
+
+	targeted map[string]struct{}
+}
+
+func (c *Correlator) newEmitter(ctx context.Context, fn func(Result)) *emitter {
+	e := &emitter{c: c, ctx: ctx, q: &resultQueue{fn: fn}, targeted: map[string]struct{}{}}
+	for _, r := range c.rules {
+		if r.Replace {
+			e.targeted[r.Source] = struct{}{}
+		}
+	}
+	return e
+}
+
+// forAlert returns emitter that marks all results as related to the given alert instance.
+func (e *emitter) forAlert(lset model.LabelSet) *emitter {
+	n := *e
+	n.alert = lset
+	return &n
+}
+
+// forSelector returns emitter that marks all results as related to the given selector.
+func (e *emitter) forSelector(sel string) *emitter {
+	n := *e
+	n.selector = sel
+	return &n
+}
+
+// wait waits for all correlations being verified to be passed to the callback.
+func (e *emitter) wait() {
+	e.q.wg.Wait()
+}
+
+func (e *emitter) discovery(d Discovery) {
+	e.q.done(e.q.push(), e.discoveryResult(d))
+}
+
+func (e *emitter) discoveryResult(d Discovery) Result {
+	return Result{Alert: e.alert, Selector: e.selector, Discovery: &d}
+}
+
+// correlation emits the correlation with status set by the verify function, which runs in the background. Status
+// is unknown if verify is nil or fails, in the latter case Error is set.
+func (e *emitter) correlation(corr Correlation, verify verifyFunc) {
+	if _, ok := e.targeted[corr.Source]; ok {
+		return
+	}
+	r := e.q.push()
+	if verify == nil {
+		corr.Status = CorrelationUnknown
+		e.q.done(r, Result{Alert: e.alert, Selector: e.selector, Correlation: &corr})
+		return
+	}
+
+	e.q.wg.Add(1)
+	go func() {
+		defer e.q.wg.Done()
+		e.q.done(r, e.verify(corr, verify)...)
+	}()
+}
+
+// verify returns the correlation with status set by the verify function, preceded by discoveries made from its
+// evidence.
+func (e *emitter) verify(corr Correlation, verify verifyFunc) []Result {
+	ctx, cancel := e.c.sourceContext(e.ctx, corr.Source)
+	defer cancel()
+
+	ok, err := verify(ctx, &corr)
+	switch {
+	case err != nil:
+		level.Warn(e.c.logger).Log("msg", "failed to verify correlation", "source", corr.Source, "url", corr.URL, "err", err)
+		corr.Status = CorrelationUnknown
+		corr.Error = NewError(&SourceError{Source: corr.Source, Err: errors.Wrap(err, "verify correlation")})
+	case ok:
+		corr.Status = CorrelationVerified
+	default:
+		corr.Status = CorrelationEmpty
+	}
+
+	var ret []Result
+	if corr.Evidence != nil && corr.Evidence.Profile != nil && len(corr.Evidence.Profile.TopFlat) > 0 {
+		top := corr.Evidence.Profile.TopFlat[0]
+		ret = append(ret, e.discoveryResult(Discovery{
+			Kind:     DiscoveryProfileHotspot,
+			Severity: DiscoverySeverityInfo,
+			Message: fmt.Sprintf("Function %v takes %.1f%% of the merged profile (%v), see %v 🔥",
+				top.Function, top.FlatPercent, corr.Evidence.Profile.Unit, corr.Description),
+			Attributes: map[string]string{
+				AttributeFunction:    top.Function,
+				AttributeFlatPercent: strconv.FormatFloat(top.FlatPercent, 'f', 1, 64),
+				AttributeUnit:        corr.Evidence.Profile.Unit,
+			},
+			Source: corr.Source,
+		}))
+	}
+	return append(ret, Result{Alert: e.alert, Selector: e.selector, Correlation: &corr})
+}
+
+// rules emits correlations from user defined correlation rules matching given data.
+func (e *emitter) rules(data TemplateData) {
+	for _, r := range e.c.rules {
+		if !r.matches(data) {
+			continue
+		}
+		data.Source = e.c.sourceConfig(r.Source)
+		corr := r.correlation(data)
+		corr.Status = CorrelationUnknown
+		if corr.Error != nil {
+			level.Warn(e.c.logger).Log("msg", "failed to produce correlation from rule", "description", r.Description, "err", corr.Error)
+		}
+		e.q.done(e.q.push(), Result{Alert: e.alert, Selector: e.selector, Correlation: &corr})
+	}
+}
+
+// resultQueue passes results to the callback one at a time, as soon as they are done, with Index set to the
+// order they were pushed in.
+type resultQueue struct {
+	fn func(Result)
+	wg sync.WaitGroup
+
+	mtx  sync.Mutex
+	next int
+}
+
+// push reserves the index for results of a single emit call that will be done later.
+func (q *resultQueue) push() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	i := q.next
+	q.next++
+	return i
+}
+
+// done passes results with the reserved index to the callback.
+func (q *resultQueue) done(index int, results ...Result) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for _, res := range results {
+		res.Index = index
+		q.fn(res)
+	}
+}
+
+func (c *Correlator) sourceConfig(name string) SourceConfig {
+	for _, sc := range c.cfg.Sources {
+		if sc.Name == name {
+			return sc
+		}
+	}
+	return SourceConfig{}
 }
@@ -0,0 +1,420 @@
+package correlator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/log"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+func TestSelectAlerts(t *testing.T) {
+	r := v1.AlertingRule{
+		Name: "PingService_TooManyErrors",
+		Alerts: []*v1.Alert{
+			{State: v1.AlertStateFiring, Labels: model.LabelSet{"alertname": "PingService_TooManyErrors", "instance": "pod-1"}},
+			{State: v1.AlertStatePending, Labels: model.LabelSet{"alertname": "PingService_TooManyErrors", "instance": "pod-2"}},
+			{State: v1.AlertStateFiring, Labels: model.LabelSet{"alertname": "PingService_TooManyErrors", "instance": "pod-3"}},
+		},
+	}
+
+	alerts, err := selectAlerts(r, Input{AlertName: r.Name})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []*v1.Alert{r.Alerts[0], r.Alerts[2]}, alerts)
+
+	alerts, err = selectAlerts(r, Input{AlertName: r.Name, AlertMatchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "instance", "pod-3")}})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []*v1.Alert{r.Alerts[2]}, alerts)
+
+	alerts, err = selectAlerts(r, Input{AlertName: r.Name, AlertFingerprint: r.Alerts[0].Labels.Fingerprint().String()})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []*v1.Alert{r.Alerts[0]}, alerts)
+
+	_, err = selectAlerts(r, Input{AlertName: r.Name, AlertMatchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "instance", "pod-2")}})
+	testutil.NotOk(t, err)
+
+	// Alert instance given directly, e.g. from Alertmanager webhook, is used even if it does not fire anymore.
+	startsAt := time.Unix(1650000000, 0)
+	alerts, err = selectAlerts(r, Input{AlertName: r.Name, AlertLabels: model.LabelSet{"alertname": "PingService_TooManyErrors", "instance": "pod-2"}, AlertStartsAt: startsAt})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []*v1.Alert{{Labels: model.LabelSet{"alertname": "PingService_TooManyErrors", "instance": "pod-2"}, ActiveAt: startsAt, State: v1.AlertStateFiring}}, alerts)
+}
+
+func TestCorrelator_SourcesFor(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+sources:
+- name: thanos
+  type: thanos
+- name: loki-eu1
+  type: loki
+  matchers: '{cluster="eu1"}'
+  config:
+    grafanaExternalEndpoint: localhost:3000
+- name: loki-us1
+  type: loki
+  matchers: '{cluster=~"us1|us2"}'
+  config:
+    grafanaExternalEndpoint: localhost:3000
+`))
+	testutil.Ok(t, err)
+	c, err := New(cfg, log.NewNopLogger())
+	testutil.Ok(t, err)
+
+	names := func(lset model.LabelSet) (ret []string) {
+		for _, s := range c.sourcesFor(lset) {
+			ret = append(ret, s.Name())
+		}
+		return ret
+	}
+	testutil.Equals(t, []string{"thanos", "loki-eu1"}, names(model.LabelSet{"cluster": "eu1", "job": "ping"}))
+	testutil.Equals(t, []string{"thanos", "loki-us1"}, names(model.LabelSet{"cluster": "us2"}))
+	testutil.Equals(t, []string{"thanos"}, names(model.LabelSet{"cluster": "eu2"}))
+	// Scope without routing label can't be routed, all sources are used.
+	testutil.Equals(t, []string{"thanos", "loki-eu1", "loki-us1"}, names(model.LabelSet{"job": "ping"}))
+}
+
+func TestCorrelator_PartialResults(t *testing.T) {
+	thanos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/rules":
+			_, _ = w.Write([]byte(`{"status":"success","data":{"groups":[{"name":"ping","file":"alert.yaml","interval":15,"rules":[
+{"type":"alerting","name":"PingService_TooManyErrors","query":"sum(rate(http_requests_total{job=\"ping\"}[1m])) > 0.3","health":"ok",
+"alerts":[{"labels":{"alertname":"PingService_TooManyErrors","job":"ping"},"state":"firing","activeAt":"2022-05-17T10:00:00Z","value":"1"}]}]}]}}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer thanos.Close()
+
+	lokiResp := `{"status":"success","data":{"resultType":"streams","result":[{"stream":{"job":"ping"},"values":[["1652781600000000000","GET /ping 500"]]}]}}`
+	fastLoki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(lokiResp))
+	}))
+	defer fastLoki.Close()
+	slowLoki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		_, _ = w.Write([]byte(lokiResp))
+	}))
+	defer slowLoki.Close()
+
+	cfg, err := ParseConfig([]byte(`
+sources:
+- type: thanos
+  internalEndpoint: ` + strings.TrimPrefix(thanos.URL, "http://") + `
+- name: loki-slow
+  type: loki
+  internalEndpoint: ` + strings.TrimPrefix(slowLoki.URL, "http://") + `
+  timeout: 100ms
+  config:
+    grafanaExternalEndpoint: localhost:3000
+- name: loki-fast
+  type: loki
+  internalEndpoint: ` + strings.TrimPrefix(fastLoki.URL, "http://") + `
+  config:
+    grafanaExternalEndpoint: localhost:3000
+`))
+	testutil.Ok(t, err)
+	c, err := New(cfg, log.NewNopLogger())
+	testutil.Ok(t, err)
+
+	resp, err := c.Correlate(context.Background(), Input{AlertName: "PingService_TooManyErrors"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(resp.Alerts))
+	testutil.Equals(t, []Discovery{{
+		Kind:     DiscoveryAlertFiring,
+		Severity: DiscoverySeverityWarning,
+		Message:  `Alert is indeed firing... 😱 Its labels: {alertname="PingService_TooManyErrors", job="ping"}`,
+		Labels:   model.LabelSet{"alertname": "PingService_TooManyErrors", "job": "ping"},
+		Source:   "thanos",
+	}}, resp.Alerts[0].Discoveries)
+
+	var sources []string
+	corrs := map[string]Correlation{}
+	for _, corr := range resp.Alerts[0].Correlations {
+		sources = append(sources, corr.Source)
+		corrs[corr.Source] = corr
+	}
+	testutil.Equals(t, []string{"loki-slow", "loki-fast"}, sources)
+	testutil.Equals(t, CorrelationUnknown, corrs["loki-slow"].Status)
+	testutil.Assert(t, corrs["loki-slow"].Error != nil, "expected error of timed out verification")
+	testutil.Equals(t, ErrorCodeSourceUnavailable, corrs["loki-slow"].Error.Code)
+	testutil.Equals(t, "loki-slow", corrs["loki-slow"].Error.Source)
+	testutil.Equals(t, CorrelationVerified, corrs["loki-fast"].Status)
+	testutil.Assert(t, corrs["loki-fast"].Error == nil, "unexpected error %v", corrs["loki-fast"].Error)
+
+	// Failed exemplars lookup does not drop the metric link.
+	testutil.Equals(t, 1, len(resp.Alerts[0].Selectors))
+	metric := resp.Alerts[0].Selectors[0].Correlations[0]
+	testutil.Equals(t, "thanos", metric.Source)
+	testutil.Assert(t, metric.Error != nil, "expected error of exemplars lookup")
+	testutil.Equals(t, ErrorCodeSourceUnavailable, metric.Error.Code)
+	testutil.Equals(t, "thanos", metric.Error.Source)
+
+	resp, err = c.Correlate(context.Background(), Input{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(resp.Discoveries))
+	testutil.Equals(t, DiscoveryTraceError, resp.Discoveries[0].Kind)
+	testutil.Equals(t, map[string]string{AttributeTraceID: "4bf92f3577b34da6a3ce929d0e0e4736"}, resp.Discoveries[0].Attributes)
+
+	_, err = c.Correlate(context.Background(), Input{AlertName: "NotExisting"})
+	testutil.Equals(t, ErrorCodeAlertNotFound, NewError(err).Code)
+	_, err = c.Correlate(context.Background(), Input{})
+	testutil.Equals(t, ErrorCodeInvalidInput, NewError(err).Code)
+}
+
+func TestCorrelator_StreamSlowSource(t *testing.T) {
+	thanos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/rules":
+			_, _ = w.Write([]byte(`{"status":"success","data":{"groups":[{"name":"ping","file":"alert.yaml","interval":15,"rules":[
+{"type":"alerting","name":"PingService_TooManyErrors","query":"sum(rate(http_requests_total{job=\"ping\"}[1m])) > 0.3","health":"ok",
+"alerts":[{"labels":{"alertname":"PingService_TooManyErrors","job":"ping"},"state":"firing","activeAt":"2022-05-17T10:00:00Z","value":"1"}]}]}]}}`))
+		default:
+			_, _ = w.Write([]byte(`{"status":"success","data":[]}`))
+		}
+	}))
+	defer thanos.Close()
+
+	lokiResp := `{"status":"success","data":{"resultType":"streams","result":[{"stream":{"job":"ping"},"values":[["1652781600000000000","GET /ping 500"]]}]}}`
+	fastLoki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(lokiResp))
+	}))
+	defer fastLoki.Close()
+	// Slow Loki answers only after the result of the fast one was passed to the callback.
+	release := make(chan struct{})
+	slowLoki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		_, _ = w.Write([]byte(lokiResp))
+	}))
+	defer slowLoki.Close()
+
+	cfg, err := ParseConfig([]byte(`
+sources:
+- type: thanos
+  internalEndpoint: ` + strings.TrimPrefix(thanos.URL, "http://") + `
+- name: loki-slow
+  type: loki
+  internalEndpoint: ` + strings.TrimPrefix(slowLoki.URL, "http://") + `
+  timeout: 10s
+  config:
+    grafanaExternalEndpoint: localhost:3000
+- name: loki-fast
+  type: loki
+  internalEndpoint: ` + strings.TrimPrefix(fastLoki.URL, "http://") + `
+  config:
+    grafanaExternalEndpoint: localhost:3000
+`))
+	testutil.Ok(t, err)
+	c, err := New(cfg, log.NewNopLogger())
+	testutil.Ok(t, err)
+
+	var got []Result
+	testutil.Ok(t, c.CorrelateStream(context.Background(), Input{AlertName: "PingService_TooManyErrors"}, func(res Result) {
+		got = append(got, res)
+		if res.Correlation != nil && res.Correlation.Source == "loki-fast" {
+			close(release)
+		}
+	}))
+
+	indexes := map[string]int{}
+	var sources []string
+	for _, res := range got {
+		if res.Correlation == nil || !strings.HasPrefix(res.Correlation.Source, "loki") {
+			continue
+		}
+		testutil.Equals(t, CorrelationVerified, res.Correlation.Status)
+		sources = append(sources, res.Correlation.Source)
+		indexes[res.Correlation.Source] = res.Index
+	}
+	testutil.Equals(t, []string{"loki-fast", "loki-slow"}, sources)
+	testutil.Assert(t, indexes["loki-slow"] < indexes["loki-fast"], "expected stable order of slow source first, got %v", indexes)
+
+	// Correlate returns results in the stable order, whichever source answers first.
+	resp, err := c.Correlate(context.Background(), Input{AlertName: "PingService_TooManyErrors"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(resp.Alerts))
+	testutil.Equals(t, 2, len(resp.Alerts[0].Correlations))
+	testutil.Equals(t, "loki-slow", resp.Alerts[0].Correlations[0].Source)
+	testutil.Equals(t, "loki-fast", resp.Alerts[0].Correlations[1].Source)
+}
+
+func TestCorrelator_Trace(t *testing.T) {
+	jaeger := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/traces/0d89ae4c473862caa8d0e79cbdfc13e4" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(jaegerTestTrace))
+	}))
+	defer jaeger.Close()
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+
+	cfg, err := ParseConfig([]byte(`
+traceLabelMapping:
+- action: replace
+  source: service
+  target: job
+  regex: demo:(.*)
+- action: keep
+  regex: job
+sources:
+- type: jaeger
+  internalEndpoint: ` + strings.TrimPrefix(jaeger.URL, "http://") + `
+  externalEndpoint: localhost:16686
+  labelMapping:
+  - action: replace
+    source: job
+    target: service
+    replacement: demo:$1
+- type: thanos
+  internalEndpoint: ` + strings.TrimPrefix(notFound.URL, "http://") + `
+  externalEndpoint: localhost:9090
+- type: loki
+  internalEndpoint: ` + strings.TrimPrefix(notFound.URL, "http://") + `
+  config:
+    grafanaExternalEndpoint: localhost:3000
+- type: parca
+  internalEndpoint: ` + strings.TrimPrefix(notFound.URL, "http://") + `
+  externalEndpoint: localhost:7070
+`))
+	testutil.Ok(t, err)
+	c, err := New(cfg, log.NewNopLogger())
+	testutil.Ok(t, err)
+
+	resp, err := c.Correlate(context.Background(), Input{TraceID: "0d89ae4c473862caa8d0e79cbdfc13e4"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, DiscoveryTraceFound, resp.Discoveries[0].Kind)
+
+	urls := correlationURLs(t, resp.Correlations)
+	testutil.Equals(t, "http://localhost:16686/trace/0d89ae4c473862caa8d0e79cbdfc13e4", urls["jaeger"])
+	testutil.Assert(t, strings.Contains(urls["loki"], `{job=\"ping\"} |= \"0d89ae4c473862caa8d0e79cbdfc13e4\"`), urls["loki"])
+	testutil.Assert(t, strings.Contains(urls["thanos"], `g0.expr=sum(rate(http_requests_total{job="ping"}[1m]))`), urls["thanos"])
+	testutil.Assert(t, strings.Contains(urls["parca"], `{profile_label_trace_id="0d89ae4c473862caa8d0e79cbdfc13e4", job="ping"}`), urls["parca"])
+
+	// 64-bit IDs are kept as they are, so they match logs printing them.
+	resp, err = c.Correlate(context.Background(), Input{TraceID: "A8D0E79CBDFC13E4"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, DiscoveryTraceError, resp.Discoveries[0].Kind)
+	urls = correlationURLs(t, resp.Correlations)
+	testutil.Assert(t, strings.Contains(urls["loki"], `|= \"a8d0e79cbdfc13e4\"`), urls["loki"])
+}
+
+// correlationURLs returns unescaped URLs of the correlations, by source.
+func correlationURLs(t *testing.T, corrs []Correlation) map[string]string {
+	urls := map[string]string{}
+	for _, corr := range corrs {
+		u, err := url.QueryUnescape(corr.URL)
+		testutil.Ok(t, err)
+		urls[corr.Source] = u
+	}
+	return urls
+}
+
+func TestCorrelator_Exemplar64(t *testing.T) {
+	thanos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/rules":
+			_, _ = w.Write([]byte(`{"status":"success","data":{"groups":[{"name":"ping","file":"alert.yaml","interval":15,"rules":[
+{"type":"alerting","name":"PingService_TooManyErrors","query":"sum(rate(http_requests_total{job=\"ping\"}[1m])) > 0.3","health":"ok",
+"alerts":[{"labels":{"alertname":"PingService_TooManyErrors","job":"ping"},"state":"firing","activeAt":"2022-05-17T10:00:00Z","value":"1"}]}]}]}}`))
+		case "/api/v1/query_exemplars":
+			_, _ = w.Write([]byte(`{"status":"success","data":[{"seriesLabels":{"__name__":"http_requests_total","job":"ping"},
+"exemplars":[{"labels":{"traceID":"a8d0e79cbdfc13e4"},"value":"1","timestamp":1652781600}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer thanos.Close()
+
+	// Logs record the 64-bit trace ID as it is.
+	loki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Query().Get("query"), `|= "a8d0e79cbdfc13e4"`) {
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[]}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[{"stream":{"job":"ping"},"values":[["1652781600000000000","GET /ping 500 traceID=a8d0e79cbdfc13e4"]]}]}}`))
+	}))
+	defer loki.Close()
+
+	cfg, err := ParseConfig([]byte(`
+sources:
+- type: thanos
+  internalEndpoint: ` + strings.TrimPrefix(thanos.URL, "http://") + `
+- type: loki
+  internalEndpoint: ` + strings.TrimPrefix(loki.URL, "http://") + `
+  config:
+    grafanaExternalEndpoint: localhost:3000
+- type: jaeger
+  internalEndpoint: ` + strings.TrimPrefix(loki.URL, "http://") + `
+  externalEndpoint: localhost:16686
+`))
+	testutil.Ok(t, err)
+	c, err := New(cfg, log.NewNopLogger())
+	testutil.Ok(t, err)
+
+	resp, err := c.Correlate(context.Background(), Input{AlertName: "PingService_TooManyErrors"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(resp.Alerts))
+	testutil.Equals(t, 1, len(resp.Alerts[0].Selectors))
+
+	corrs := map[string]Correlation{}
+	for _, corr := range resp.Alerts[0].Selectors[0].Correlations {
+		corrs[corr.Source] = corr
+	}
+	u, err := url.QueryUnescape(corrs["loki"].URL)
+	testutil.Ok(t, err)
+	testutil.Assert(t, strings.Contains(u, `{job=\"ping\"} |= \"a8d0e79cbdfc13e4\"`), u)
+	testutil.Equals(t, CorrelationVerified, corrs["loki"].Status)
+	// Jaeger expects zero-padded 128-bit IDs.
+	testutil.Equals(t, "http://localhost:16686/trace/0000000000000000a8d0e79cbdfc13e4", corrs["jaeger"].URL)
+}
+
+func TestCorrelator_Query(t *testing.T) {
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+
+	cfg, err := ParseConfig([]byte(`
+sources:
+- name: thanos-eu1
+  type: thanos
+  internalEndpoint: ` + strings.TrimPrefix(notFound.URL, "http://") + `
+- name: thanos-us1
+  type: thanos
+  internalEndpoint: ` + strings.TrimPrefix(notFound.URL, "http://") + `
+- name: thanos-pong
+  type: thanos
+  internalEndpoint: ` + strings.TrimPrefix(notFound.URL, "http://") + `
+  matchers: '{job="pong"}'
+`))
+	testutil.Ok(t, err)
+	c, err := New(cfg, log.NewNopLogger())
+	testutil.Ok(t, err)
+
+	resp, err := c.Correlate(context.Background(), Input{Query: `rate(http_requests_total{job="ping"}[1m])`})
+	testutil.Ok(t, err)
+	testutil.Equals(t, DiscoveryMetricsSource, resp.Discoveries[0].Kind)
+	testutil.Equals(t, "thanos-eu1", resp.Discoveries[0].Source)
+	testutil.Equals(t, map[string]string{AttributeIgnoredSources: "thanos-us1"}, resp.Discoveries[0].Attributes)
+
+	cfg.Sources = cfg.Sources[2:]
+	c, err = New(cfg, log.NewNopLogger())
+	testutil.Ok(t, err)
+	_, err = c.Correlate(context.Background(), Input{Query: `rate(http_requests_total{job="ping"}[1m])`})
+	testutil.Equals(t, ErrorCodeInvalidInput, NewError(err).Code)
+}
@@ -0,0 +1,204 @@
+package correlator
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// ElasticsearchConfig is an "elasticsearch" source type specific configuration. It works with OpenSearch too.
+type ElasticsearchConfig struct {
+	// Index is the index (or index pattern) with logs, e.g. "logs-*".
+	Index string
+	// TimestampField is a field with log timestamp. Defaults to "@timestamp".
+	TimestampField string `json:",omitempty"`
+	// MessageField is a field with log message. Defaults to "message".
+	MessageField string `json:",omitempty"`
+	// TraceIDField is a field with trace ID. Defaults to "trace.id".
+	TraceIDField string `json:",omitempty"`
+	// LabelFields maps scope label names to fields with the same values. Only mapped labels are used to filter
+	// logs. Defaults to {"job": "service.name"}.
+	LabelFields map[string]string `json:",omitempty"`
+
+	// DashboardsExternalEndpoint is an endpoint of Kibana or OpenSearch Dashboards used for viewing logs in
+	// Discover.
+	DashboardsExternalEndpoint string
+	// DataViewID is the ID of Kibana data view (OpenSearch Dashboards index pattern) for the index. Discover uses
+	// default one if empty.
+	DataViewID string `json:",omitempty"`
+}
+
+type elasticsearchSource struct {
+	baseSource
+
+	cfg ElasticsearchConfig
+}
+
+func newElasticsearchSource(cfg SourceConfig, typeCfg []byte, _ log.Logger) (Source, error) {
+	b, err := newBaseSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &elasticsearchSource{baseSource: b}
+	if err := yaml.Unmarshal(typeCfg, &s.cfg); err != nil {
+		return nil, errors.Wrap(err, "parse Elasticsearch config")
+	}
+	if s.cfg.Index == "" {
+		return nil, errors.New("Index is required for Elasticsearch source")
+	}
+	if s.cfg.DashboardsExternalEndpoint == "" {
+		return nil, errors.New("DashboardsExternalEndpoint is required for Elasticsearch source")
+	}
+	if s.cfg.TimestampField == "" {
+		s.cfg.TimestampField = "@timestamp"
+	}
+	if s.cfg.MessageField == "" {
+		s.cfg.MessageField = "message"
+	}
+	if s.cfg.TraceIDField == "" {
+		s.cfg.TraceIDField = "trace.id"
+	}
+	if s.cfg.LabelFields == nil {
+		s.cfg.LabelFields = map[string]string{"job": "service.name"}
+	}
+	return s, nil
+}
+
+func (s *elasticsearchSource) Healthy(ctx context.Context) error {
+	return s.healthy(ctx, "/_cluster/health")
+}
+
+// fieldValues returns field values the logs for the scope have to have, sorted by field name.
+func (s *elasticsearchSource) fieldValues(scope Scope) [][2]string {
+	var ret [][2]string
+	for l, f := range s.cfg.LabelFields {
+		if v := scope.Labels[model.LabelName(l)]; v != "" {
+			ret = append(ret, [2]string{f, string(v)})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i][0] < ret[j][0] })
+	if scope.TraceID != "" {
+		ret = append(ret, [2]string{s.cfg.TraceIDField, scope.TraceID})
+	}
+	return ret
+}
+
+// kqlQuery returns Kibana Query Language query selecting logs for the scope.
+func (s *elasticsearchSource) kqlQuery(scope Scope) string {
+	var terms []string
+	for _, fv := range s.fieldValues(scope) {
+		terms = append(terms, fmt.Sprintf(`%s:"%s"`, fv[0], strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(fv[1])))
+	}
+	return strings.Join(terms, " and ")
+}
+
+// risonString encodes string in Rison format used by Kibana URL state.
+func risonString(v string) string {
+	return "'" + strings.NewReplacer("!", "!!", "'", "!'").Replace(v) + "'"
+}
+
+func (s *elasticsearchSource) LogsURL(scope Scope) string {
+	g := fmt.Sprintf("(time:(from:%s,to:%s))",
+		risonString(scope.Start.UTC().Format(time.RFC3339Nano)),
+		risonString(scope.End.UTC().Format(time.RFC3339Nano)),
+	)
+	a := fmt.Sprintf("(query:(language:kuery,query:%s))", risonString(s.kqlQuery(scope)))
+	if s.cfg.DataViewID != "" {
+		a = fmt.Sprintf("(index:%s,query:(language:kuery,query:%s))", risonString(s.cfg.DataViewID), risonString(s.kqlQuery(scope)))
+	}
+	return s.tenantLinkURL(s.cfg.DashboardsExternalEndpoint, scope.Tenant, "/app/discover#/?_g="+url.PathEscape(g)+"&_a="+url.PathEscape(a))
+}
+
+// searchRequest returns _search request body selecting up to size latest logs for the scope.
+func (s *elasticsearchSource) searchRequest(scope Scope, size int) map[string]interface{} {
+	filter := []interface{}{
+		map[string]interface{}{"range": map[string]interface{}{
+			s.cfg.TimestampField: map[string]interface{}{
+				"gte":    scope.Start.UTC().Format(time.RFC3339Nano),
+				"lte":    scope.End.UTC().Format(time.RFC3339Nano),
+				"format": "strict_date_optional_time",
+			},
+		}},
+	}
+	for _, fv := range s.fieldValues(scope) {
+		filter = append(filter, map[string]interface{}{"term": map[string]interface{}{fv[0]: fv[1]}})
+	}
+	return map[string]interface{}{
+		"size":  size,
+		"sort":  []interface{}{map[string]interface{}{s.cfg.TimestampField: "desc"}},
+		"query": map[string]interface{}{"bool": map[string]interface{}{"filter": filter}},
+	}
+}
+
+type elasticsearchSearchResponse struct {
+	Hits struct {
+		Hits []struct {
+			Source map[string]interface{} `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// field returns value of the field from the document, whether it's stored flat ("a.b") or nested.
+func field(doc map[string]interface{}, name string) (interface{}, bool) {
+	if v, ok := doc[name]; ok {
+		return v, true
+	}
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	nested, ok := doc[parts[0]].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return field(nested, parts[1])
+}
+
+func (s *elasticsearchSource) search(ctx context.Context, scope Scope, limit int) ([]LogLine, error) {
+	var resp elasticsearchSearchResponse
+	if err := s.post(ctx, "/"+url.PathEscape(s.cfg.Index)+"/_search", s.searchRequest(scope, limit), &resp); err != nil {
+		return nil, err
+	}
+
+	lines := make([]LogLine, 0, len(resp.Hits.Hits))
+	for _, h := range resp.Hits.Hits {
+		l := LogLine{Labels: model.LabelSet{}}
+		if v, ok := field(h.Source, s.cfg.TimestampField); ok {
+			ts, err := time.Parse(time.RFC3339Nano, fmt.Sprintf("%v", v))
+			if err != nil {
+				return nil, errors.Wrapf(err, "%v source: parse %v field", s.Name(), s.cfg.TimestampField)
+			}
+			l.Timestamp = ts
+		}
+		if v, ok := field(h.Source, s.cfg.MessageField); ok {
+			l.Line = fmt.Sprintf("%v", v)
+		}
+		for lbl, f := range s.cfg.LabelFields {
+			if v, ok := field(h.Source, f); ok {
+				l.Labels[model.LabelName(lbl)] = model.LabelValue(fmt.Sprintf("%v", v))
+			}
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+func (s *elasticsearchSource) LogsExist(ctx context.Context, scope Scope) (bool, error) {
+	lines, err := s.search(ctx, scope, 1)
+	if err != nil {
+		return false, err
+	}
+	return len(lines) > 0, nil
+}
+
+func (s *elasticsearchSource) Logs(ctx context.Context, scope Scope, limit int) ([]LogLine, error) {
+	return logsTraceFirst(ctx, scope, limit, s.search)
+}
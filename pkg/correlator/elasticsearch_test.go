@@ -0,0 +1,78 @@
+package correlator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+)
+
+func TestElasticsearchSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/logs-*/_search" {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			Size  int
+			Query struct {
+				Bool struct {
+					Filter []map[string]map[string]interface{}
+				}
+			}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		terms := map[string]interface{}{}
+		for _, f := range req.Query.Bool.Filter {
+			for k, v := range f["term"] {
+				terms[k] = v
+			}
+		}
+		switch {
+		case terms["service.name"] != "ping":
+			_, _ = w.Write([]byte(`{"hits":{"hits":[]}}`))
+		case terms["trace.id"] == "0d89ae4c473862caa8d0e79cbdfc13e4":
+			_, _ = w.Write([]byte(`{"hits":{"hits":[{"_source":{"@timestamp":"2022-04-15T05:20:01Z","message":"error","service":{"name":"ping"},"trace.id":"0d89ae4c473862caa8d0e79cbdfc13e4"}}]}}`))
+		default:
+			_, _ = w.Write([]byte(`{"hits":{"hits":[
+{"_source":{"@timestamp":"2022-04-15T05:20:03Z","message":"ok","service":{"name":"ping"}}},
+{"_source":{"@timestamp":"2022-04-15T05:20:01Z","message":"error","service":{"name":"ping"},"trace.id":"0d89ae4c473862caa8d0e79cbdfc13e4"}}]}}`))
+		}
+	}))
+	defer srv.Close()
+
+	s, err := newElasticsearchSource(
+		SourceConfig{Name: "es", Type: "elasticsearch", InternalEndpoint: strings.TrimPrefix(srv.URL, "http://")},
+		[]byte("index: logs-*\ndashboardsExternalEndpoint: localhost:5601"),
+		log.NewNopLogger(),
+	)
+	testutil.Ok(t, err)
+
+	scope := Scope{Labels: model.LabelSet{"job": "ping"}, TraceID: "0d89ae4c473862caa8d0e79cbdfc13e4", Start: time.Unix(1650000000, 0), End: time.Unix(1650003600, 0)}
+	testutil.Equals(t,
+		"http://localhost:5601/app/discover#/?_g=%28time:%28from:%272022-04-15T05:20:00Z%27%2Cto:%272022-04-15T06:20:00Z%27%29%29&_a=%28query:%28language:kuery%2Cquery:%27service.name:%22ping%22%20and%20trace.id:%220d89ae4c473862caa8d0e79cbdfc13e4%22%27%29%29",
+		s.(LogsSource).LogsURL(scope),
+	)
+
+	lines, err := s.(LogsFetcher).Logs(context.Background(), scope, 10)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []LogLine{
+		{Timestamp: time.Date(2022, 4, 15, 5, 20, 1, 0, time.UTC), Labels: model.LabelSet{"job": "ping"}, Line: "error", MatchesTrace: true},
+		{Timestamp: time.Date(2022, 4, 15, 5, 20, 3, 0, time.UTC), Labels: model.LabelSet{"job": "ping"}, Line: "ok"},
+	}, lines)
+
+	scope.Labels = model.LabelSet{"job": "pong"}
+	ok, err := s.(LogsVerifier).LogsExist(context.Background(), scope)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok)
+}
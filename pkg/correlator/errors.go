@@ -0,0 +1,115 @@
+package correlator
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidInput is returned when the correlation input is incomplete or malformed.
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrAlertNotFound is returned when the requested alert is not defined in any metrics source.
+	ErrAlertNotFound = errors.New("alert not found in any metrics source")
+	// ErrAlertNotFiring is returned when the requested alert (or its selected instance) does not fire.
+	ErrAlertNotFiring = errors.New("alert not firing")
+	// ErrNoSelectors is returned when the alert expression or query has no series selectors to correlate.
+	ErrNoSelectors = errors.New("no series selectors")
+	// ErrNoExemplars is set on metric correlations when no exemplar with trace ID was found for the selector.
+	ErrNoExemplars = errors.New("no exemplars with trace ID")
+	// ErrSourceUnavailable is matched by errors of calls to the source, see SourceError.
+	ErrSourceUnavailable = errors.New("source unavailable")
+)
+
+// SourceError is returned when a call to the source failed, e.g. it timed out or responded with error. It matches
+// ErrSourceUnavailable with errors.Is.
+type SourceError struct {
+	Source string
+	Err    error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("source %v: %v", e.Source, e.Err)
+}
+
+func (e *SourceError) Unwrap() error { return e.Err }
+
+func (e *SourceError) Is(target error) bool { return target == ErrSourceUnavailable }
+
+// ErrorCode tells why the correlation or its part failed. Codes are stable, so clients can branch on them.
+type ErrorCode string
+
+const (
+	ErrorCodeInvalidInput      ErrorCode = "invalid_input"
+	ErrorCodeAlertNotFound     ErrorCode = "alert_not_found"
+	ErrorCodeAlertNotFiring    ErrorCode = "alert_not_firing"
+	ErrorCodeNoSelectors       ErrorCode = "no_selectors"
+	ErrorCodeNoExemplars       ErrorCode = "no_exemplars"
+	ErrorCodeSourceUnavailable ErrorCode = "source_unavailable"
+	ErrorCodeNotFound          ErrorCode = "not_found"
+	ErrorCodeInternal          ErrorCode = "internal"
+)
+
+// errorCodes maps errors to codes, in order of precedence.
+var errorCodes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{err: ErrInvalidInput, code: ErrorCodeInvalidInput},
+	{err: ErrAlertNotFound, code: ErrorCodeAlertNotFound},
+	{err: ErrAlertNotFiring, code: ErrorCodeAlertNotFiring},
+	{err: ErrNoSelectors, code: ErrorCodeNoSelectors},
+	{err: ErrNoExemplars, code: ErrorCodeNoExemplars},
+	{err: ErrSourceUnavailable, code: ErrorCodeSourceUnavailable},
+	{err: ErrNotFound, code: ErrorCodeNotFound},
+}
+
+// HTTPStatus returns HTTP status code of responses failed with the error code.
+func (c ErrorCode) HTTPStatus() int {
+	switch c {
+	case ErrorCodeInvalidInput:
+		return http.StatusBadRequest
+	case ErrorCodeAlertNotFound, ErrorCodeNoExemplars, ErrorCodeNotFound:
+		return http.StatusNotFound
+	case ErrorCodeAlertNotFiring:
+		return http.StatusConflict
+	case ErrorCodeNoSelectors:
+		return http.StatusUnprocessableEntity
+	case ErrorCodeSourceUnavailable:
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// Error describes error of the correlation or its part in a stable JSON format.
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	// Source is the name of the source that failed, if any.
+	Source string `json:"source,omitempty"`
+}
+
+// NewError returns Error describing the given error. Error code is internal unless the error matches one of
+// the errors defined in this package.
+func NewError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	e = &Error{Code: ErrorCodeInternal, Message: err.Error()}
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			e.Code = ec.code
+			break
+		}
+	}
+	var se *SourceError
+	if errors.As(err, &se) {
+		e.Source = se.Source
+	}
+	return e
+}
+
+func (e *Error) Error() string { return e.Message }
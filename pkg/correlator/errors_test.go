@@ -0,0 +1,52 @@
+package correlator
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/pkg/errors"
+)
+
+func TestNewError(t *testing.T) {
+	for _, tcase := range []struct {
+		err    error
+		expect Error
+		status int
+	}{
+		{
+			err:    errors.Wrapf(ErrAlertNotFound, "alertname %v", "PingService_TooManyErrors"),
+			expect: Error{Code: ErrorCodeAlertNotFound, Message: "alertname PingService_TooManyErrors: alert not found in any metrics source"},
+			status: http.StatusNotFound,
+		},
+		{
+			err:    &SourceError{Source: "loki", Err: errors.New(`unexpected status code 500: "oops"`)},
+			expect: Error{Code: ErrorCodeSourceUnavailable, Message: `source loki: unexpected status code 500: "oops"`, Source: "loki"},
+			status: http.StatusBadGateway,
+		},
+		{
+			// Source errors take precedence over errors returned by the source.
+			err:    errors.Wrap(&SourceError{Source: "jaeger", Err: errors.Wrap(ErrNotFound, "trace 1")}, "verify"),
+			expect: Error{Code: ErrorCodeSourceUnavailable, Message: "verify: source jaeger: trace 1: not found", Source: "jaeger"},
+			status: http.StatusBadGateway,
+		},
+		{
+			err:    errors.New("something else"),
+			expect: Error{Code: ErrorCodeInternal, Message: "something else"},
+			status: http.StatusInternalServerError,
+		},
+	} {
+		t.Run(tcase.expect.Message, func(t *testing.T) {
+			e := NewError(tcase.err)
+			testutil.Equals(t, tcase.expect, *e)
+			testutil.Equals(t, tcase.status, e.Code.HTTPStatus())
+			// Already converted errors are returned as they are.
+			testutil.Equals(t, e, NewError(errors.Wrap(e, "wrapped")))
+		})
+	}
+
+	b, err := json.Marshal(Correlation{Error: NewError(&SourceError{Source: "loki", Err: errors.New(`bad "query"`)})})
+	testutil.Ok(t, err)
+	testutil.Equals(t, `{"Error":{"code":"source_unavailable","message":"source loki: bad \"query\"","source":"loki"},"Description":"","URL":"","Source":"","Status":""}`, string(b))
+}
@@ -0,0 +1,91 @@
+package correlator
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Evidence is observability data inlined in the correlation, so it can be seen without opening the link.
+type Evidence struct {
+	LogLines []LogLine       `json:",omitempty"`
+	Trace    *TraceSummary   `json:",omitempty"`
+	Profile  *ProfileSummary `json:",omitempty"`
+}
+
+// LogLine is a single log line.
+type LogLine struct {
+	Timestamp time.Time
+	Labels    model.LabelSet
+	Line      string
+	// MatchesTrace is true if the line was found by the scope trace ID. Other lines only give the context.
+	MatchesTrace bool `json:",omitempty"`
+}
+
+// logsTraceFirst returns up to limit log lines for the scope using fetch. If scope has trace ID, lines with it come
+// first and have MatchesTrace set, followed by other lines for the scope (without trace ID filter) for context.
+func logsTraceFirst(ctx context.Context, scope Scope, limit int, fetch func(ctx context.Context, scope Scope, limit int) ([]LogLine, error)) ([]LogLine, error) {
+	lines, err := fetch(ctx, scope, limit)
+	if err != nil {
+		return nil, err
+	}
+	if scope.TraceID == "" {
+		return lines, nil
+	}
+	for i := range lines {
+		lines[i].MatchesTrace = true
+	}
+	if len(lines) >= limit {
+		return lines, nil
+	}
+
+	other := scope
+	other.TraceID = ""
+	more, err := fetch(ctx, other, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		ts     int64
+		labels model.Fingerprint
+		line   string
+	}
+	seen := make(map[key]struct{}, len(lines))
+	for _, l := range lines {
+		seen[key{ts: l.Timestamp.UnixNano(), labels: l.Labels.Fingerprint(), line: l.Line}] = struct{}{}
+	}
+	for _, l := range more {
+		if len(lines) >= limit {
+			break
+		}
+		if _, ok := seen[key{ts: l.Timestamp.UnixNano(), labels: l.Labels.Fingerprint(), line: l.Line}]; ok {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+// ProfileSummary is a summary of the profile, telling which functions use the most resources.
+type ProfileSummary struct {
+	// Unit of values, e.g. "nanoseconds" for CPU profiles.
+	Unit string
+	// Total is the total value of the profile.
+	Total int64
+	// TopFlat are functions with the highest flat (self) value.
+	TopFlat []FunctionValue
+	// TopCumulative are functions with the highest cumulative value (self and callees).
+	TopCumulative []FunctionValue
+}
+
+// FunctionValue is a value of a single function in the profile.
+type FunctionValue struct {
+	Function   string
+	Flat       int64
+	Cumulative int64
+	// FlatPercent and CumulativePercent are values as the percentage of the profile total.
+	FlatPercent       float64
+	CumulativePercent float64
+}
@@ -0,0 +1,152 @@
+package correlator
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+)
+
+type jaegerSource struct {
+	baseSource
+}
+
+func newJaegerSource(cfg SourceConfig, _ []byte, _ log.Logger) (Source, error) {
+	b, err := newBaseSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &jaegerSource{baseSource: b}, nil
+}
+
+func (s *jaegerSource) Healthy(ctx context.Context) error {
+	return s.healthy(ctx, "/api/services")
+}
+
+// traceID returns trace ID zero-padded to 128 bits, as Jaeger expects.
+func (s *jaegerSource) traceID(id string) string {
+	n, err := normalizeTraceID(id, TraceIDFormatHex128)
+	if err != nil {
+		// Let Jaeger deal with it.
+		return id
+	}
+	return n
+}
+
+func (s *jaegerSource) TraceURL(scope Scope) string {
+	return s.externalURL(scope.Tenant, "/trace/"+url.PathEscape(s.traceID(scope.TraceID)))
+}
+
+func (s *jaegerSource) TracesSearchURL(scope Scope) string {
+	v := s.searchParams(scope)
+	v.Set("limit", "20")
+	v.Set("lookback", "custom")
+	return s.externalURL(scope.Tenant, "/search?"+v.Encode())
+}
+
+// searchParams returns parameters of traces search for the scope, common for UI and API. Traces are searched for
+// the "service" scope label.
+func (s *jaegerSource) searchParams(scope Scope) url.Values {
+	v := url.Values{}
+	// Jaeger expects microseconds.
+	v.Set("start", strconv.FormatInt(scope.Start.UnixNano()/int64(time.Microsecond), 10))
+	v.Set("end", strconv.FormatInt(scope.End.UnixNano()/int64(time.Microsecond), 10))
+	if service := scope.Labels["service"]; service != "" {
+		v.Set("service", string(service))
+	}
+	return v
+}
+
+func (s *jaegerSource) TracesExist(ctx context.Context, scope Scope) (bool, error) {
+	v := s.searchParams(scope)
+	v.Set("limit", "1")
+
+	var resp jaegerTracesResponse
+	if err := s.get(ctx, "/api/traces?"+v.Encode(), &resp); err != nil {
+		return false, err
+	}
+	return len(resp.Data) > 0, nil
+}
+
+// jaegerKeyValue is a tag in Jaeger JSON API format.
+type jaegerKeyValue struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+type jaegerTracesResponse struct {
+	Data []struct {
+		TraceID string `json:"traceID"`
+		Spans   []struct {
+			TraceID       string `json:"traceID"`
+			SpanID        string `json:"spanID"`
+			OperationName string `json:"operationName"`
+			References    []struct {
+				RefType string `json:"refType"`
+				SpanID  string `json:"spanID"`
+			} `json:"references"`
+			// StartTime and Duration are in microseconds.
+			StartTime int64            `json:"startTime"`
+			Duration  int64            `json:"duration"`
+			Tags      []jaegerKeyValue `json:"tags"`
+			ProcessID string           `json:"processID"`
+		} `json:"spans"`
+		Processes map[string]struct {
+			ServiceName string           `json:"serviceName"`
+			Tags        []jaegerKeyValue `json:"tags"`
+		} `json:"processes"`
+	} `json:"data"`
+}
+
+func jaegerTags(kvs []jaegerKeyValue) map[string]string {
+	if len(kvs) == 0 {
+		return nil
+	}
+	ret := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		ret[kv.Key] = fmt.Sprintf("%v", kv.Value)
+	}
+	return ret
+}
+
+func (s *jaegerSource) Trace(ctx context.Context, traceID string) (*Trace, error) {
+	traceID = s.traceID(traceID)
+	var resp jaegerTracesResponse
+	if err := s.get(ctx, "/api/traces/"+url.PathEscape(traceID), &resp); err != nil {
+		if isNotFound(err) {
+			return nil, errors.Wrapf(ErrNotFound, "%v source: trace %v", s.Name(), traceID)
+		}
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, errors.Wrapf(ErrNotFound, "%v source: trace %v", s.Name(), traceID)
+	}
+
+	d := resp.Data[0]
+	t := &Trace{TraceID: d.TraceID, Spans: make([]Span, 0, len(d.Spans))}
+	for _, sp := range d.Spans {
+		span := Span{
+			SpanID:        sp.SpanID,
+			OperationName: sp.OperationName,
+			Start:         time.Unix(0, sp.StartTime*int64(time.Microsecond)),
+			Duration:      time.Duration(sp.Duration) * time.Microsecond,
+			Tags:          jaegerTags(sp.Tags),
+		}
+		for _, ref := range sp.References {
+			if ref.RefType == "CHILD_OF" {
+				span.ParentSpanID = ref.SpanID
+				break
+			}
+		}
+		if p, ok := d.Processes[sp.ProcessID]; ok {
+			span.Service = p.ServiceName
+			span.Resource = jaegerTags(p.Tags)
+		}
+		t.Spans = append(t.Spans, span)
+	}
+	return t, nil
+}
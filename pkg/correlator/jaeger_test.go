@@ -0,0 +1,61 @@
+package correlator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+)
+
+const jaegerTestTrace = `{"data":[{"traceID":"0d89ae4c473862caa8d0e79cbdfc13e4","spans":[
+{"traceID":"0d89ae4c473862caa8d0e79cbdfc13e4","spanID":"b7ad6b7169203331","operationName":"/ping","references":[],
+ "startTime":1650000000000000,"duration":20000,"tags":[{"key":"http.status_code","type":"int64","value":200}],"processID":"p1"},
+{"traceID":"0d89ae4c473862caa8d0e79cbdfc13e4","spanID":"00f067aa0ba902b7","operationName":"db","references":[{"refType":"CHILD_OF","traceID":"0d89ae4c473862caa8d0e79cbdfc13e4","spanID":"b7ad6b7169203331"}],
+ "startTime":1650000000005000,"duration":30000,"tags":[{"key":"error","type":"bool","value":true}],"processID":"p1"}],
+"processes":{"p1":{"serviceName":"demo:ping","tags":[{"key":"hostname","type":"string","value":"ping-1"}]}}}]}`
+
+func TestJaegerSource_Trace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/traces/0d89ae4c473862caa8d0e79cbdfc13e4" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(jaegerTestTrace))
+	}))
+	defer srv.Close()
+
+	s, err := newJaegerSource(SourceConfig{Name: "jaeger", Type: "jaeger", InternalEndpoint: strings.TrimPrefix(srv.URL, "http://")}, nil, log.NewNopLogger())
+	testutil.Ok(t, err)
+
+	trace, err := s.(TraceFetcher).Trace(context.Background(), "0d89ae4c473862caa8d0e79cbdfc13e4")
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(trace.Spans))
+
+	root := trace.Root()
+	testutil.Equals(t, "/ping", root.OperationName)
+	testutil.Equals(t, "demo:ping", root.Service)
+	testutil.Equals(t, map[string]string{"hostname": "ping-1"}, root.Resource)
+	testutil.Equals(t, map[string]string{"http.status_code": "200"}, root.Tags)
+	testutil.Equals(t, "b7ad6b7169203331", trace.Spans[1].ParentSpanID)
+	testutil.Equals(t, time.Unix(1650000000, 0), trace.Start())
+	testutil.Equals(t, time.Unix(1650000000, 35*int64(time.Millisecond)), trace.End())
+
+	testutil.Equals(t, TraceSummary{
+		TraceID:     "0d89ae4c473862caa8d0e79cbdfc13e4",
+		Duration:    35 * time.Millisecond,
+		SpanCount:   2,
+		Services:    []string{"demo:ping"},
+		ErrorSpans:  []SpanSummary{{SpanID: "00f067aa0ba902b7", Service: "demo:ping", OperationName: "db", Duration: 30 * time.Millisecond, Tags: map[string]string{"error": "true"}}},
+		SlowestSpan: &SpanSummary{SpanID: "00f067aa0ba902b7", Service: "demo:ping", OperationName: "db", Duration: 30 * time.Millisecond, Tags: map[string]string{"error": "true"}},
+	}, trace.Summary())
+
+	_, err = s.(TraceFetcher).Trace(context.Background(), "a8d0e79cbdfc13e4")
+	testutil.NotOk(t, err)
+	testutil.Equals(t, ErrNotFound, errors.Cause(err))
+}
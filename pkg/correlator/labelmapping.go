@@ -0,0 +1,167 @@
+package correlator
+
+import (
+	"bytes"
+	"regexp"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// LabelMappingRename moves value of Source label to Target label.
+	LabelMappingRename = "rename"
+	// LabelMappingReplace writes Replacement to Target label if Regex matches value of Source label.
+	LabelMappingReplace = "replace"
+	// LabelMappingTemplate writes result of Template to Target label. Empty result removes Target label.
+	LabelMappingTemplate = "template"
+	// LabelMappingDrop removes labels with names matching Regex.
+	LabelMappingDrop = "drop"
+	// LabelMappingKeep removes labels with names not matching Regex.
+	LabelMappingKeep = "keep"
+)
+
+// LabelMappingRule translates labels of the correlation scope (e.g. alert or series labels) to the equivalent
+// labels of other signal, e.g. metric "job" label to Loki "jobs" label. Rules are applied in order.
+type LabelMappingRule struct {
+	// Action is one of "rename" (default), "replace", "template", "drop" or "keep".
+	Action string `json:",omitempty"`
+	// Source is the name of the label to read, for "rename" and "replace" actions.
+	Source string `json:",omitempty"`
+	// Target is the name of the label to write, for "rename", "replace" and "template" actions. Defaults to
+	// Source.
+	Target string `json:",omitempty"`
+	// Regex is anchored regular expression matched against Source label value for "replace" action (defaults
+	// to "(.*)") or against label names for "drop" and "keep" actions.
+	Regex string `json:",omitempty"`
+	// Replacement is written to Target label for "replace" action, with $1-style references to Regex capture
+	// groups. Defaults to "$1".
+	Replacement string `json:",omitempty"`
+	// Template is a Go text/template producing Target label value for "template" action, e.g.
+	// `e2e-correlation-{{ .Labels.job }}:8080`. Template has access to all current labels via .Labels.
+	Template string `json:",omitempty"`
+}
+
+// labelMapping is a parsed list of LabelMappingRule.
+type labelMapping []labelMappingRule
+
+type labelMappingRule struct {
+	LabelMappingRule
+
+	regex *regexp.Regexp
+	tmpl  *template.Template
+}
+
+// defaultLabelMappings are label mappings used for sources without LabelMapping configured, by source type.
+var defaultLabelMappings = map[string][]LabelMappingRule{
+	"loki":   {{Action: LabelMappingKeep, Regex: "job"}},
+	"parca":  {{Action: LabelMappingKeep, Regex: "job"}},
+	"jaeger": {{Action: LabelMappingRename, Source: "job", Target: "service"}},
+}
+
+// defaultTraceLabelMapping is the trace label mapping used if TraceLabelMapping is not configured. It reverses
+// the default "jaeger" mapping.
+var defaultTraceLabelMapping = []LabelMappingRule{
+	{Action: LabelMappingRename, Source: "service", Target: "job"},
+	{Action: LabelMappingKeep, Regex: "job"},
+}
+
+func newLabelMapping(rules []LabelMappingRule) (labelMapping, error) {
+	ret := make(labelMapping, 0, len(rules))
+	for i, r := range rules {
+		lr := labelMappingRule{LabelMappingRule: r}
+		if lr.Action == "" {
+			lr.Action = LabelMappingRename
+		}
+		if lr.Target == "" {
+			lr.Target = lr.Source
+		}
+
+		switch lr.Action {
+		case LabelMappingRename:
+			if lr.Source == "" {
+				return nil, errors.Errorf("label mapping %d: source is required for %v action", i, lr.Action)
+			}
+		case LabelMappingReplace:
+			if lr.Source == "" {
+				return nil, errors.Errorf("label mapping %d: source is required for %v action", i, lr.Action)
+			}
+			if lr.Regex == "" {
+				lr.Regex = "(.*)"
+			}
+			if lr.Replacement == "" {
+				lr.Replacement = "$1"
+			}
+		case LabelMappingTemplate:
+			if lr.Target == "" {
+				return nil, errors.Errorf("label mapping %d: target is required for %v action", i, lr.Action)
+			}
+			t, err := template.New("").Option("missingkey=zero").Parse(lr.Template)
+			if err != nil {
+				return nil, errors.Wrapf(err, "label mapping %d: parse template", i)
+			}
+			lr.tmpl = t
+		case LabelMappingDrop, LabelMappingKeep:
+			if lr.Regex == "" {
+				return nil, errors.Errorf("label mapping %d: regex is required for %v action", i, lr.Action)
+			}
+		default:
+			return nil, errors.Errorf("label mapping %d: unknown action %q", i, lr.Action)
+		}
+
+		if lr.Regex != "" {
+			re, err := regexp.Compile("^(?:" + lr.Regex + ")$")
+			if err != nil {
+				return nil, errors.Wrapf(err, "label mapping %d: parse regex", i)
+			}
+			lr.regex = re
+		}
+		ret = append(ret, lr)
+	}
+	return ret, nil
+}
+
+// apply returns labels translated by all rules. Given labels are not modified.
+func (m labelMapping) apply(lset model.LabelSet) (model.LabelSet, error) {
+	ret := lset.Clone()
+	for i, r := range m {
+		switch r.Action {
+		case LabelMappingRename:
+			v, ok := ret[model.LabelName(r.Source)]
+			if !ok {
+				continue
+			}
+			delete(ret, model.LabelName(r.Source))
+			ret[model.LabelName(r.Target)] = v
+		case LabelMappingReplace:
+			v, ok := ret[model.LabelName(r.Source)]
+			if !ok {
+				continue
+			}
+			match := r.regex.FindStringSubmatchIndex(string(v))
+			if match == nil {
+				continue
+			}
+			res := r.regex.ExpandString(nil, r.Replacement, string(v), match)
+			ret[model.LabelName(r.Target)] = model.LabelValue(res)
+		case LabelMappingTemplate:
+			var b bytes.Buffer
+			if err := r.tmpl.Execute(&b, struct{ Labels map[string]string }{Labels: labelsMap(ret)}); err != nil {
+				return ret, errors.Wrapf(err, "label mapping %d: execute template", i)
+			}
+			if b.Len() == 0 {
+				delete(ret, model.LabelName(r.Target))
+				continue
+			}
+			ret[model.LabelName(r.Target)] = model.LabelValue(b.String())
+		case LabelMappingDrop, LabelMappingKeep:
+			for n := range ret {
+				if r.regex.MatchString(string(n)) == (r.Action == LabelMappingDrop) {
+					delete(ret, n)
+				}
+			}
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,64 @@
+package correlator
+
+import (
+	"testing"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/prometheus/common/model"
+)
+
+func TestLabelMapping(t *testing.T) {
+	alert := model.LabelSet{"alertname": "PingService_TooManyErrors", "job": "ping", "instance": "ping:8080", "severity": "page"}
+
+	for _, tcase := range []struct {
+		name     string
+		rules    []LabelMappingRule
+		expected model.LabelSet
+	}{
+		{name: "no rules", expected: alert},
+		{
+			name:     "loki default",
+			rules:    defaultLabelMappings["loki"],
+			expected: model.LabelSet{"job": "ping"},
+		},
+		{
+			name:     "rename and keep",
+			rules:    []LabelMappingRule{{Source: "job", Target: "jobs"}, {Action: LabelMappingKeep, Regex: "jobs"}},
+			expected: model.LabelSet{"jobs": "ping"},
+		},
+		{
+			name: "replace",
+			rules: []LabelMappingRule{
+				{Action: LabelMappingReplace, Source: "job", Target: "service", Replacement: "demo:$1"},
+				{Action: LabelMappingReplace, Source: "instance", Regex: "(.+):8080", Replacement: "$1"},
+				{Action: LabelMappingReplace, Source: "severity", Target: "not-matching", Regex: "warning"},
+				{Action: LabelMappingDrop, Regex: "alertname|severity|job"},
+			},
+			expected: model.LabelSet{"service": "demo:ping", "instance": "ping"},
+		},
+		{
+			name: "template",
+			rules: []LabelMappingRule{
+				{Action: LabelMappingTemplate, Target: "job", Template: "e2e-correlation-{{ .Labels.job }}:8080"},
+				{Action: LabelMappingTemplate, Target: "cluster", Template: "{{ .Labels.cluster }}"},
+				{Action: LabelMappingKeep, Regex: "job|cluster"},
+			},
+			expected: model.LabelSet{"job": "e2e-correlation-ping:8080"},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			m, err := newLabelMapping(tcase.rules)
+			testutil.Ok(t, err)
+			lset, err := m.apply(alert)
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, lset)
+		})
+	}
+
+	_, err := newLabelMapping([]LabelMappingRule{{Action: "relabel"}})
+	testutil.NotOk(t, err)
+	_, err = newLabelMapping([]LabelMappingRule{{Action: LabelMappingDrop}})
+	testutil.NotOk(t, err)
+	_, err = newLabelMapping([]LabelMappingRule{{Action: LabelMappingTemplate, Target: "job", Template: "{{ .Labels.job"}})
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,134 @@
+package correlator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// LokiConfig is a "loki" source type specific configuration.
+type LokiConfig struct {
+	// GrafanaExternalEndpoint is an endpoint of Grafana used for viewing logs, since Loki does not have its own UI.
+	GrafanaExternalEndpoint string
+	// GrafanaDatasource is a name of Loki datasource in Grafana. Defaults to "Logging".
+	GrafanaDatasource string `json:",omitempty"`
+}
+
+type lokiSource struct {
+	baseSource
+
+	cfg LokiConfig
+}
+
+func newLokiSource(cfg SourceConfig, typeCfg []byte, _ log.Logger) (Source, error) {
+	b, err := newBaseSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &lokiSource{baseSource: b}
+	if err := yaml.Unmarshal(typeCfg, &s.cfg); err != nil {
+		return nil, errors.Wrap(err, "parse Loki config")
+	}
+	if s.cfg.GrafanaExternalEndpoint == "" {
+		return nil, errors.New("GrafanaExternalEndpoint is required for Loki source")
+	}
+	if s.cfg.GrafanaDatasource == "" {
+		s.cfg.GrafanaDatasource = "Logging"
+	}
+	return s, nil
+}
+
+func (s *lokiSource) Healthy(ctx context.Context) error {
+	return s.healthy(ctx, "/ready")
+}
+
+// query returns LogQL query selecting logs for the scope. All scope labels are used as stream selector, so they
+// have to be mapped to Loki labels with source LabelMapping.
+func (s *lokiSource) query(scope Scope) string {
+	query := `{job=~".+"}`
+	if len(scope.Labels) > 0 {
+		query = scope.Labels.String()
+	}
+	if scope.TraceID != "" {
+		query += fmt.Sprintf(" |= %s", strconv.Quote(scope.TraceID))
+	}
+	return query
+}
+
+func (s *lokiSource) LogsURL(scope Scope) string {
+	query := s.query(scope)
+
+	// Grafana Explore state is a JSON array of: from, to (both in unix milliseconds), datasource and query.
+	left, _ := json.Marshal([]interface{}{
+		strconv.FormatInt(unixMillis(scope.Start), 10),
+		strconv.FormatInt(unixMillis(scope.End), 10),
+		s.cfg.GrafanaDatasource,
+		map[string]string{"refId": "A", "expr": query},
+	})
+	return s.linkURL(s.cfg.GrafanaExternalEndpoint, "/explore?orgId="+s.grafanaOrgID(scope.Tenant)+"&left="+url.QueryEscape(string(left)))
+}
+
+type lokiQueryResponse struct {
+	Data struct {
+		Result []struct {
+			Stream model.LabelSet `json:"stream"`
+			// Values are pairs of timestamp in unix nanoseconds and log line.
+			Values [][2]string `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// queryRange returns up to limit latest log lines matching query in the scope time window.
+func (s *lokiSource) queryRange(ctx context.Context, query string, scope Scope, limit int) ([]LogLine, error) {
+	v := url.Values{}
+	v.Set("query", query)
+	v.Set("start", strconv.FormatInt(scope.Start.UnixNano(), 10))
+	v.Set("end", strconv.FormatInt(scope.End.UnixNano(), 10))
+	v.Set("limit", strconv.Itoa(limit))
+	v.Set("direction", "backward")
+
+	var resp lokiQueryResponse
+	if err := s.get(ctx, "/loki/api/v1/query_range?"+v.Encode(), &resp); err != nil {
+		return nil, err
+	}
+
+	var lines []LogLine
+	for _, r := range resp.Data.Result {
+		for _, val := range r.Values {
+			ns, err := strconv.ParseInt(val[0], 10, 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%v source: parse log line timestamp %q", s.Name(), val[0])
+			}
+			lines = append(lines, LogLine{Timestamp: time.Unix(0, ns), Labels: r.Stream, Line: val[1]})
+		}
+	}
+	// Lines are sorted only within streams, merge them.
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Timestamp.After(lines[j].Timestamp) })
+	if len(lines) > limit {
+		lines = lines[:limit]
+	}
+	return lines, nil
+}
+
+func (s *lokiSource) LogsExist(ctx context.Context, scope Scope) (bool, error) {
+	lines, err := s.queryRange(ctx, s.query(scope), scope, 1)
+	if err != nil {
+		return false, err
+	}
+	return len(lines) > 0, nil
+}
+
+func (s *lokiSource) Logs(ctx context.Context, scope Scope, limit int) ([]LogLine, error) {
+	return logsTraceFirst(ctx, scope, limit, func(ctx context.Context, scope Scope, limit int) ([]LogLine, error) {
+		return s.queryRange(ctx, s.query(scope), scope, limit)
+	})
+}
@@ -0,0 +1,113 @@
+package correlator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+)
+
+func TestLokiSource_LogsExist(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/query_range" || r.URL.Query().Get("limit") != "1" {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.Contains(r.URL.Query().Get("query"), `{jobs="ping"}`) {
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[{"stream":{"jobs":"ping"},"values":[["1650000000000000000","GET /ping"]]}]}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[]}}`))
+	}))
+	defer srv.Close()
+
+	s, err := newLokiSource(SourceConfig{Name: "loki", Type: "loki", InternalEndpoint: strings.TrimPrefix(srv.URL, "http://")}, []byte("grafanaExternalEndpoint: localhost:3000"), log.NewNopLogger())
+	testutil.Ok(t, err)
+	v := s.(LogsVerifier)
+
+	scope := Scope{Start: time.Unix(1650000000, 0), End: time.Unix(1650003600, 0)}
+	scope.Labels = model.LabelSet{"jobs": "ping"}
+	ok, err := v.LogsExist(context.Background(), scope)
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok)
+
+	scope.Labels = model.LabelSet{"jobs": "pong"}
+	ok, err = v.LogsExist(context.Background(), scope)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok)
+}
+
+func TestLokiSource_Query(t *testing.T) {
+	s, err := newLokiSource(SourceConfig{Name: "loki", Type: "loki"}, []byte("grafanaExternalEndpoint: localhost:3000"), log.NewNopLogger())
+	testutil.Ok(t, err)
+	l := s.(*lokiSource)
+
+	for _, tcase := range []struct {
+		name     string
+		scope    Scope
+		expected string
+	}{
+		{name: "no labels", expected: `{job=~".+"}`},
+		{name: "labels", scope: Scope{Labels: model.LabelSet{"job": "ping", "namespace": "demo"}}, expected: `{job="ping", namespace="demo"}`},
+		{
+			name:     "trace ID",
+			scope:    Scope{Labels: model.LabelSet{"job": "ping"}, TraceID: "0d89ae4c473862caa8d0e79cbdfc13e4"},
+			expected: `{job="ping"} |= "0d89ae4c473862caa8d0e79cbdfc13e4"`,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			testutil.Equals(t, tcase.expected, l.query(tcase.scope))
+		})
+	}
+}
+
+func TestLokiSource_Logs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("query") {
+		case `{jobs="ping"} |= "0d89ae4c473862caa8d0e79cbdfc13e4"`:
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[
+{"stream":{"jobs":"ping"},"values":[["1650000001000000000","error traceID=0d89ae4c473862caa8d0e79cbdfc13e4"]]}]}}`))
+		case `{jobs="ping"}`:
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[
+{"stream":{"jobs":"ping","level":"info"},"values":[["1650000003000000000","ok"],["1650000000000000000","ok"]]},
+{"stream":{"jobs":"ping"},"values":[["1650000001000000000","error traceID=0d89ae4c473862caa8d0e79cbdfc13e4"]]}]}}`))
+		default:
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[]}}`))
+		}
+	}))
+	defer srv.Close()
+
+	s, err := newLokiSource(SourceConfig{Name: "loki", Type: "loki", InternalEndpoint: strings.TrimPrefix(srv.URL, "http://")}, []byte("grafanaExternalEndpoint: localhost:3000"), log.NewNopLogger())
+	testutil.Ok(t, err)
+
+	scope := Scope{Labels: model.LabelSet{"jobs": "ping"}, TraceID: "0d89ae4c473862caa8d0e79cbdfc13e4", Start: time.Unix(1650000000, 0), End: time.Unix(1650003600, 0)}
+	lines, err := s.(LogsFetcher).Logs(context.Background(), scope, 3)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []LogLine{
+		{Timestamp: time.Unix(1650000001, 0), Labels: model.LabelSet{"jobs": "ping"}, Line: "error traceID=0d89ae4c473862caa8d0e79cbdfc13e4", MatchesTrace: true},
+		{Timestamp: time.Unix(1650000003, 0), Labels: model.LabelSet{"jobs": "ping", "level": "info"}, Line: "ok"},
+		{Timestamp: time.Unix(1650000000, 0), Labels: model.LabelSet{"jobs": "ping", "level": "info"}, Line: "ok"},
+	}, lines)
+
+	c := &Correlator{cfg: Config{Evidence: EvidenceConfig{LogLines: 3}}}
+	corr := Correlation{}
+	ok, err := c.verifyLogs(s, scope)(context.Background(), &corr)
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, 3, len(corr.Evidence.LogLines))
+
+	// Lines without the trace ID are only the context, the link filtered by it leads to no data.
+	scope.TraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	corr = Correlation{}
+	ok, err = c.verifyLogs(s, scope)(context.Background(), &corr)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok)
+	testutil.Equals(t, 3, len(corr.Evidence.LogLines))
+	testutil.Assert(t, !corr.Evidence.LogLines[0].MatchesTrace)
+}
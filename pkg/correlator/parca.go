@@ -0,0 +1,178 @@
+package correlator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+type parcaSource struct {
+	baseSource
+}
+
+func newParcaSource(cfg SourceConfig, _ []byte, _ log.Logger) (Source, error) {
+	b, err := newBaseSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &parcaSource{baseSource: b}, nil
+}
+
+func (s *parcaSource) Healthy(ctx context.Context) error {
+	return s.healthy(ctx, "/")
+}
+
+// expression returns Parca query selecting CPU profiles for the scope. All scope labels are used as matchers, so
+// they have to be mapped to Parca labels with source LabelMapping.
+func (s *parcaSource) expression(scope Scope) string {
+	var matchers []string
+	if scope.TraceID != "" {
+		matchers = append(matchers, fmt.Sprintf("profile_label_trace_id=%s", strconv.Quote(scope.TraceID)))
+	}
+	names := make(model.LabelNames, 0, len(scope.Labels))
+	for n := range scope.Labels {
+		names = append(names, n)
+	}
+	sort.Sort(names)
+	for _, n := range names {
+		matchers = append(matchers, fmt.Sprintf("%s=%s", n, strconv.Quote(string(scope.Labels[n]))))
+	}
+	return "process_cpu:cpu:nanoseconds:cpu:nanoseconds:delta{" + strings.Join(matchers, ", ") + "}"
+}
+
+func (s *parcaSource) ProfilesURL(scope Scope) string {
+	from, to := strconv.FormatInt(unixMillis(scope.Start), 10), strconv.FormatInt(unixMillis(scope.End), 10)
+
+	v := url.Values{}
+	v.Set("currentProfileView", "icicle")
+	v.Set("expression_a", s.expression(scope))
+	v.Set("merge_a", "true")
+	v.Set("from_a", from)
+	v.Set("to_a", to)
+	v.Set("time_selection_a", "absolute:"+from+"-"+to)
+	return s.externalURL(scope.Tenant, "/?"+v.Encode())
+}
+
+type parcaQueryRangeResponse struct {
+	Series []json.RawMessage `json:"series"`
+}
+
+func (s *parcaSource) ProfilesExist(ctx context.Context, scope Scope) (bool, error) {
+	v := url.Values{}
+	v.Set("query", s.expression(scope))
+	v.Set("start", scope.Start.UTC().Format(time.RFC3339))
+	v.Set("end", scope.End.UTC().Format(time.RFC3339))
+	v.Set("limit", "1")
+
+	var resp parcaQueryRangeResponse
+	if err := s.get(ctx, "/profiles/query_range?"+v.Encode(), &resp); err != nil {
+		return false, err
+	}
+	return len(resp.Series) > 0, nil
+}
+
+// parcaQueryResponse is the Parca QueryService Query response with the top report.
+type parcaQueryResponse struct {
+	Top struct {
+		List []struct {
+			Meta struct {
+				Location struct {
+					Address json.Number `json:"address"`
+				} `json:"location"`
+				Function struct {
+					Name string `json:"name"`
+				} `json:"function"`
+			} `json:"meta"`
+			Cumulative json.Number `json:"cumulative"`
+			Flat       json.Number `json:"flat"`
+		} `json:"list"`
+		Unit string `json:"unit"`
+	} `json:"top"`
+	Total json.Number `json:"total"`
+}
+
+func (s *parcaSource) TopFunctions(ctx context.Context, scope Scope, limit int) (*ProfileSummary, error) {
+	v := url.Values{}
+	v.Set("mode", "MODE_MERGE")
+	v.Set("report_type", "REPORT_TYPE_TOP")
+	v.Set("merge.query", s.expression(scope))
+	v.Set("merge.start", scope.Start.UTC().Format(time.RFC3339))
+	v.Set("merge.end", scope.End.UTC().Format(time.RFC3339))
+
+	var resp parcaQueryResponse
+	if err := s.get(ctx, "/profiles/query?"+v.Encode(), &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Top.List) == 0 {
+		return nil, nil
+	}
+
+	ps := &ProfileSummary{Unit: resp.Top.Unit}
+	if resp.Total != "" {
+		total, err := resp.Total.Int64()
+		if err != nil {
+			return nil, errors.Wrapf(err, "%v source: parse profile total", s.Name())
+		}
+		ps.Total = total
+	}
+
+	// Top report has an entry per location, aggregate them by function.
+	var (
+		funcs []FunctionValue
+		index = map[string]int{}
+	)
+	for _, n := range resp.Top.List {
+		name := n.Meta.Function.Name
+		if name == "" {
+			addr, _ := strconv.ParseUint(n.Meta.Location.Address.String(), 10, 64)
+			name = "0x" + strconv.FormatUint(addr, 16)
+		}
+		flat, err := n.Flat.Int64()
+		if err != nil {
+			return nil, errors.Wrapf(err, "%v source: parse flat value of %v", s.Name(), name)
+		}
+		cum, err := n.Cumulative.Int64()
+		if err != nil {
+			return nil, errors.Wrapf(err, "%v source: parse cumulative value of %v", s.Name(), name)
+		}
+
+		i, ok := index[name]
+		if !ok {
+			i = len(funcs)
+			index[name] = i
+			funcs = append(funcs, FunctionValue{Function: name})
+		}
+		funcs[i].Flat += flat
+		funcs[i].Cumulative += cum
+	}
+	for i := range funcs {
+		if ps.Total > 0 {
+			funcs[i].FlatPercent = 100 * float64(funcs[i].Flat) / float64(ps.Total)
+			funcs[i].CumulativePercent = 100 * float64(funcs[i].Cumulative) / float64(ps.Total)
+		}
+	}
+
+	ps.TopFlat = topFunctions(funcs, limit, func(f FunctionValue) int64 { return f.Flat })
+	ps.TopCumulative = topFunctions(funcs, limit, func(f FunctionValue) int64 { return f.Cumulative })
+	return ps, nil
+}
+
+// topFunctions returns up to limit functions with the highest value.
+func topFunctions(funcs []FunctionValue, limit int, value func(FunctionValue) int64) []FunctionValue {
+	sorted := make([]FunctionValue, len(funcs))
+	copy(sorted, funcs)
+	sort.SliceStable(sorted, func(i, j int) bool { return value(sorted[i]) > value(sorted[j]) })
+	if len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
@@ -0,0 +1,60 @@
+package correlator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+)
+
+func TestParcaSource_TopFunctions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/profiles/query" || q.Get("mode") != "MODE_MERGE" || q.Get("report_type") != "REPORT_TYPE_TOP" {
+			http.NotFound(w, r)
+			return
+		}
+		if !strings.Contains(q.Get("merge.query"), `job="ping"`) {
+			_, _ = w.Write([]byte(`{"top":{"list":[],"unit":"nanoseconds"},"total":"0"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"top":{"list":[
+{"meta":{"function":{"name":"main.nastyBugIAccidentialyPut"}},"cumulative":"600","flat":"500"},
+{"meta":{"function":{"name":"main.nastyBugIAccidentialyPut"}},"cumulative":"100","flat":"100"},
+{"meta":{"function":{"name":"main.main"}},"cumulative":"1000","flat":"100"},
+{"meta":{"location":{"address":"4096"}},"cumulative":"50","flat":"50"}
+],"unit":"nanoseconds"},"total":"1000"}`))
+	}))
+	defer srv.Close()
+
+	s, err := newParcaSource(SourceConfig{Name: "parca", Type: "parca", InternalEndpoint: strings.TrimPrefix(srv.URL, "http://")}, nil, log.NewNopLogger())
+	testutil.Ok(t, err)
+	f := s.(ProfilesFetcher)
+
+	scope := Scope{Labels: model.LabelSet{"job": "ping"}, Start: time.Unix(1650000000, 0), End: time.Unix(1650003600, 0)}
+	ps, err := f.TopFunctions(context.Background(), scope, 2)
+	testutil.Ok(t, err)
+	testutil.Equals(t, &ProfileSummary{
+		Unit:  "nanoseconds",
+		Total: 1000,
+		TopFlat: []FunctionValue{
+			{Function: "main.nastyBugIAccidentialyPut", Flat: 600, Cumulative: 700, FlatPercent: 60, CumulativePercent: 70},
+			{Function: "main.main", Flat: 100, Cumulative: 1000, FlatPercent: 10, CumulativePercent: 100},
+		},
+		TopCumulative: []FunctionValue{
+			{Function: "main.main", Flat: 100, Cumulative: 1000, FlatPercent: 10, CumulativePercent: 100},
+			{Function: "main.nastyBugIAccidentialyPut", Flat: 600, Cumulative: 700, FlatPercent: 60, CumulativePercent: 70},
+		},
+	}, ps)
+
+	scope.Labels = model.LabelSet{"job": "pong"}
+	ps, err = f.TopFunctions(context.Background(), scope, 2)
+	testutil.Ok(t, err)
+	testutil.Assert(t, ps == nil)
+}
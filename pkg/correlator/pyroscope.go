@@ -0,0 +1,166 @@
+package correlator
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+)
+
+// PyroscopeConfig is a "pyroscope" source type specific configuration.
+type PyroscopeConfig struct {
+	// ProfileType is the profile type to show. Defaults to "process_cpu:cpu:nanoseconds:cpu:nanoseconds".
+	ProfileType string `json:",omitempty"`
+	// ServiceNameLabel is a profile label holding the name of the service, matched against "service" or "job"
+	// scope label. Defaults to "service_name".
+	ServiceNameLabel string `json:",omitempty"`
+	// TraceIDLabel is a profile label holding trace ID, if applications label profiles with it (span profiles).
+	// If empty, profiles are not filtered by trace ID.
+	TraceIDLabel string `json:",omitempty"`
+}
+
+type pyroscopeSource struct {
+	baseSource
+
+	cfg PyroscopeConfig
+}
+
+func newPyroscopeSource(cfg SourceConfig, typeCfg []byte, _ log.Logger) (Source, error) {
+	b, err := newBaseSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &pyroscopeSource{baseSource: b}
+	if err := yaml.Unmarshal(typeCfg, &s.cfg); err != nil {
+		return nil, errors.Wrap(err, "parse Pyroscope config")
+	}
+	if s.cfg.ProfileType == "" {
+		s.cfg.ProfileType = "process_cpu:cpu:nanoseconds:cpu:nanoseconds"
+	}
+	if s.cfg.ServiceNameLabel == "" {
+		s.cfg.ServiceNameLabel = "service_name"
+	}
+	return s, nil
+}
+
+func (s *pyroscopeSource) Healthy(ctx context.Context) error {
+	return s.healthy(ctx, "/ready")
+}
+
+// query returns Pyroscope query selecting profiles for the scope.
+func (s *pyroscopeSource) query(scope Scope) string {
+	var matchers []string
+	service := scope.Labels["service"]
+	if service == "" {
+		service = scope.Labels["job"]
+	}
+	if service != "" {
+		matchers = append(matchers, fmt.Sprintf("%s=%s", s.cfg.ServiceNameLabel, strconv.Quote(string(service))))
+	}
+	if scope.TraceID != "" && s.cfg.TraceIDLabel != "" {
+		matchers = append(matchers, fmt.Sprintf("%s=%s", s.cfg.TraceIDLabel, strconv.Quote(scope.TraceID)))
+	}
+	return s.cfg.ProfileType + "{" + strings.Join(matchers, ", ") + "}"
+}
+
+// timeParams returns query and time range parameters for the scope. Pyroscope expects unix seconds.
+func (s *pyroscopeSource) timeParams(scope Scope) url.Values {
+	v := url.Values{}
+	v.Set("query", s.query(scope))
+	v.Set("from", strconv.FormatInt(scope.Start.Unix(), 10))
+	v.Set("until", strconv.FormatInt(scope.End.Unix(), 10))
+	return v
+}
+
+func (s *pyroscopeSource) ProfilesURL(scope Scope) string {
+	return s.externalURL(scope.Tenant, "/?"+s.timeParams(scope).Encode())
+}
+
+// pyroscopeRenderResponse is the Pyroscope render API response in flamebearer format.
+type pyroscopeRenderResponse struct {
+	Flamebearer struct {
+		Names []string `json:"names"`
+		// Levels hold nodes of the flamegraph per depth, each as 4 numbers: offset, total, self and name index.
+		Levels   [][]int64 `json:"levels"`
+		NumTicks int64     `json:"numTicks"`
+	} `json:"flamebearer"`
+	Metadata struct {
+		Units string `json:"units"`
+	} `json:"metadata"`
+}
+
+func (s *pyroscopeSource) render(ctx context.Context, scope Scope) (pyroscopeRenderResponse, error) {
+	v := s.timeParams(scope)
+	v.Set("format", "json")
+
+	var resp pyroscopeRenderResponse
+	if err := s.get(ctx, "/pyroscope/render?"+v.Encode(), &resp); err != nil {
+		return pyroscopeRenderResponse{}, err
+	}
+	return resp, nil
+}
+
+func (s *pyroscopeSource) ProfilesExist(ctx context.Context, scope Scope) (bool, error) {
+	resp, err := s.render(ctx, scope)
+	if err != nil {
+		return false, err
+	}
+	return resp.Flamebearer.NumTicks > 0, nil
+}
+
+func (s *pyroscopeSource) TopFunctions(ctx context.Context, scope Scope, limit int) (*ProfileSummary, error) {
+	resp, err := s.render(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	fb := resp.Flamebearer
+	if fb.NumTicks == 0 {
+		return nil, nil
+	}
+
+	var (
+		funcs []FunctionValue
+		index = map[string]int{}
+	)
+	for depth, level := range fb.Levels {
+		if len(level)%4 != 0 {
+			return nil, errors.Errorf("%v source: unexpected flamebearer level length %d", s.Name(), len(level))
+		}
+		for i := 0; i < len(level); i += 4 {
+			total, self, nameIdx := level[i+1], level[i+2], level[i+3]
+			if nameIdx < 0 || int(nameIdx) >= len(fb.Names) {
+				return nil, errors.Errorf("%v source: flamebearer name index %d out of range", s.Name(), nameIdx)
+			}
+			if depth == 0 {
+				// Root node represents the whole profile.
+				continue
+			}
+
+			name := fb.Names[nameIdx]
+			j, ok := index[name]
+			if !ok {
+				j = len(funcs)
+				index[name] = j
+				funcs = append(funcs, FunctionValue{Function: name})
+			}
+			funcs[j].Flat += self
+			funcs[j].Cumulative += total
+		}
+	}
+	for i := range funcs {
+		funcs[i].FlatPercent = 100 * float64(funcs[i].Flat) / float64(fb.NumTicks)
+		funcs[i].CumulativePercent = 100 * float64(funcs[i].Cumulative) / float64(fb.NumTicks)
+	}
+
+	return &ProfileSummary{
+		Unit:          resp.Metadata.Units,
+		Total:         fb.NumTicks,
+		TopFlat:       topFunctions(funcs, limit, func(f FunctionValue) int64 { return f.Flat }),
+		TopCumulative: topFunctions(funcs, limit, func(f FunctionValue) int64 { return f.Cumulative }),
+	}, nil
+}
@@ -0,0 +1,59 @@
+package correlator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+)
+
+func TestPyroscopeSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pyroscope/render" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("query") != `process_cpu:cpu:nanoseconds:cpu:nanoseconds{service_name="ping", trace_id="0d89ae4c473862caa8d0e79cbdfc13e4"}` {
+			_, _ = w.Write([]byte(`{"flamebearer":{"names":["total"],"levels":[[0,0,0,0]],"numTicks":0},"metadata":{"units":"samples"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"flamebearer":{"names":["total","main.main","main.nastyBugIAccidentialyPut","runtime.gcBgMarkWorker"],
+"levels":[[0,1000,0,0],[0,900,100,1,0,100,100,3],[0,800,800,2]],"numTicks":1000},"metadata":{"units":"samples"}}`))
+	}))
+	defer srv.Close()
+
+	s, err := newPyroscopeSource(SourceConfig{Name: "pyroscope", Type: "pyroscope", InternalEndpoint: strings.TrimPrefix(srv.URL, "http://"), ExternalEndpoint: "localhost:4040"}, []byte("traceIDLabel: trace_id"), log.NewNopLogger())
+	testutil.Ok(t, err)
+
+	scope := Scope{Labels: model.LabelSet{"job": "ping"}, TraceID: "0d89ae4c473862caa8d0e79cbdfc13e4", Start: time.Unix(1650000000, 0), End: time.Unix(1650003600, 0)}
+	testutil.Equals(t,
+		"http://localhost:4040/?from=1650000000&query=process_cpu%3Acpu%3Ananoseconds%3Acpu%3Ananoseconds%7Bservice_name%3D%22ping%22%2C+trace_id%3D%220d89ae4c473862caa8d0e79cbdfc13e4%22%7D&until=1650003600",
+		s.(ProfilesSource).ProfilesURL(scope),
+	)
+
+	ps, err := s.(ProfilesFetcher).TopFunctions(context.Background(), scope, 2)
+	testutil.Ok(t, err)
+	testutil.Equals(t, &ProfileSummary{
+		Unit:  "samples",
+		Total: 1000,
+		TopFlat: []FunctionValue{
+			{Function: "main.nastyBugIAccidentialyPut", Flat: 800, Cumulative: 800, FlatPercent: 80, CumulativePercent: 80},
+			{Function: "main.main", Flat: 100, Cumulative: 900, FlatPercent: 10, CumulativePercent: 90},
+		},
+		TopCumulative: []FunctionValue{
+			{Function: "main.main", Flat: 100, Cumulative: 900, FlatPercent: 10, CumulativePercent: 90},
+			{Function: "main.nastyBugIAccidentialyPut", Flat: 800, Cumulative: 800, FlatPercent: 80, CumulativePercent: 80},
+		},
+	}, ps)
+
+	scope.TraceID = ""
+	ok, err := s.(ProfilesVerifier).ProfilesExist(context.Background(), scope)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok)
+}
@@ -0,0 +1,154 @@
+package correlator
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const (
+	ExemplarFound    = "found"
+	ExemplarNotFound = "not_found"
+)
+
+// CorrelationRule is a user defined correlation, which allows to add or change links without recompiling
+// correlator. Rules add links to the built-in ones, unless Replace is set.
+type CorrelationRule struct {
+	Description string
+	// Source is the name of the source the link points to.
+	Source string
+	// When specifies conditions to emit this correlation. All conditions have to be met.
+	When CorrelationCondition
+	// URL is a Go text/template (https://pkg.go.dev/text/template) producing the link. See TemplateData for
+	// the available fields. Additionally, following functions are available: queryEscape, pathEscape, json,
+	// selector, unixMillis and rfc3339.
+	URL string
+	// Replace drops all built-in links for the Source, regardless of When conditions. Rules replacing links
+	// should cover all cases they are needed in, e.g. both when exemplar is found and not found.
+	Replace bool `json:",omitempty"`
+}
+
+type CorrelationCondition struct {
+	// Exemplar can be "found" or "not_found". If empty, rule applies in both cases.
+	Exemplar string `json:",omitempty"`
+	// LabelsPresent is a list of label names that have to be present in alert labels.
+	LabelsPresent []string `json:",omitempty"`
+}
+
+// TemplateData is the data passed to CorrelationRule URL template.
+type TemplateData struct {
+	// Labels are labels of the firing alert. When correlating from query, those are labels selected with the same
+	// value by all its selectors. When correlating from trace ID, those are "service" label with the
+	// name of the root span service and resource attributes of its process.
+	Labels map[string]string
+	// Matchers are matchers of the series selector from the alert expression.
+	Matchers []*labels.Matcher
+	// TraceID is the trace ID found in exemplar or the requested one. Empty if not found.
+	TraceID string
+	// Start and End represent time range of the correlation.
+	Start, End time.Time
+	// Source is the configuration of the target source, e.g. to use {{ .Source.ExternalEndpoint }}.
+	Source SourceConfig
+	// Tenant is the tenant of the correlation, empty if not set.
+	Tenant string
+}
+
+var templateFuncs = template.FuncMap{
+	"queryEscape": url.QueryEscape,
+	"pathEscape":  url.PathEscape,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// selector renders PromQL/LogQL series selector from the given matchers.
+	"selector": func(ms []*labels.Matcher) string {
+		s := make([]string, 0, len(ms))
+		for _, m := range ms {
+			s = append(s, m.String())
+		}
+		return "{" + strings.Join(s, ",") + "}"
+	},
+	"unixMillis": unixMillis,
+	"rfc3339":    func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func labelsMap(lset model.LabelSet) map[string]string {
+	m := make(map[string]string, len(lset))
+	for k, v := range lset {
+		m[string(k)] = string(v)
+	}
+	return m
+}
+
+func labelSet(m map[string]string) model.LabelSet {
+	lset := make(model.LabelSet, len(m))
+	for k, v := range m {
+		lset[model.LabelName(k)] = model.LabelValue(v)
+	}
+	return lset
+}
+
+// correlationRule is a CorrelationRule with parsed template.
+type correlationRule struct {
+	CorrelationRule
+
+	tmpl *template.Template
+}
+
+func newCorrelationRule(r CorrelationRule) (*correlationRule, error) {
+	if r.Source == "" {
+		return nil, errors.New("source is required")
+	}
+	switch r.When.Exemplar {
+	case "", ExemplarFound, ExemplarNotFound:
+	default:
+		return nil, errors.Errorf("unknown exemplar condition %q, expected %q or %q", r.When.Exemplar, ExemplarFound, ExemplarNotFound)
+	}
+	tmpl, err := template.New(r.Description).Funcs(templateFuncs).Option("missingkey=zero").Parse(r.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse URL template")
+	}
+	return &correlationRule{CorrelationRule: r, tmpl: tmpl}, nil
+}
+
+func (r *correlationRule) matches(data TemplateData) bool {
+	switch r.When.Exemplar {
+	case ExemplarFound:
+		if data.TraceID == "" {
+			return false
+		}
+	case ExemplarNotFound:
+		if data.TraceID != "" {
+			return false
+		}
+	}
+	for _, l := range r.When.LabelsPresent {
+		if _, ok := data.Labels[l]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *correlationRule) correlation(data TemplateData) Correlation {
+	corr := Correlation{Description: r.Description, Source: r.Source}
+
+	b := bytes.Buffer{}
+	if err := r.tmpl.Execute(&b, data); err != nil {
+		corr.Error = NewError(errors.Wrap(err, "execute URL template"))
+		return corr
+	}
+	corr.URL = b.String()
+	return corr
+}
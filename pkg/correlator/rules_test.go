@@ -0,0 +1,154 @@
+package correlator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+func TestCorrelationRule_Validate(t *testing.T) {
+	for _, tcase := range []struct {
+		name        string
+		rule        CorrelationRule
+		expectedErr string
+	}{
+		{
+			name: "valid",
+			rule: CorrelationRule{
+				Description: "Logs for the trace",
+				Source:      "loki",
+				When:        CorrelationCondition{Exemplar: ExemplarFound},
+				URL:         `http://{{ .Source.ExternalEndpoint }}/explore?expr={{ print (selector .Matchers) " |= " (json .TraceID) | queryEscape }}`,
+			},
+		},
+		{
+			name: "undefined template function",
+			rule: CorrelationRule{
+				Description: "Logs for the trace",
+				Source:      "loki",
+				URL:         `http://{{ .Source.ExternalEndpoint }}/explore?left={{ json (list "A" .TraceID) | queryEscape }}`,
+			},
+			expectedErr: `correlation 0: parse URL template: template: Logs for the trace:1: function "list" not defined`,
+		},
+		{
+			name:        "unknown exemplar condition",
+			rule:        CorrelationRule{Description: "Logs", Source: "loki", When: CorrelationCondition{Exemplar: "maybe"}},
+			expectedErr: `correlation 0: unknown exemplar condition "maybe", expected "found" or "not_found"`,
+		},
+		{
+			name:        "missing source",
+			rule:        CorrelationRule{Description: "Logs"},
+			expectedErr: "correlation 0: source is required",
+		},
+		{
+			name:        "unknown source",
+			rule:        CorrelationRule{Description: "Traces", Source: "tempo"},
+			expectedErr: `correlation 0: source "tempo" not found`,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			cfg := Config{Sources: []SourceConfig{{Type: "loki"}}, Correlations: []CorrelationRule{tcase.rule}}
+			err := cfg.Validate()
+			if tcase.expectedErr == "" {
+				testutil.Ok(t, err)
+				return
+			}
+			testutil.NotOk(t, err)
+			testutil.Equals(t, tcase.expectedErr, err.Error())
+		})
+	}
+}
+
+func TestCorrelationRule(t *testing.T) {
+	r, err := newCorrelationRule(CorrelationRule{
+		Description: "Logs for the trace",
+		Source:      "loki",
+		When:        CorrelationCondition{Exemplar: ExemplarFound, LabelsPresent: []string{"job"}},
+		URL:         `http://{{ .Source.ExternalEndpoint }}/explore?expr={{ print (selector .Matchers) " |= " (json .TraceID) | queryEscape }}&from={{ unixMillis .Start }}&job={{ .Labels.job }}`,
+	})
+	testutil.Ok(t, err)
+
+	for _, tcase := range []struct {
+		name        string
+		data        TemplateData
+		expectedURL string
+	}{
+		{
+			name: "matches",
+			data: TemplateData{
+				Labels:   map[string]string{"job": "ping"},
+				Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "ping")},
+				TraceID:  "abc",
+				Start:    time.Unix(10, 0),
+				Source:   SourceConfig{ExternalEndpoint: "localhost:3000"},
+			},
+			expectedURL: `http://localhost:3000/explore?expr=%7Bjob%3D%22ping%22%7D+%7C%3D+%22abc%22&from=10000&job=ping`,
+		},
+		{
+			name: "no exemplar",
+			data: TemplateData{Labels: map[string]string{"job": "ping"}},
+		},
+		{
+			name: "missing label",
+			data: TemplateData{Labels: map[string]string{"instance": "a"}, TraceID: "abc"},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			if tcase.expectedURL == "" {
+				testutil.Assert(t, !r.matches(tcase.data))
+				return
+			}
+			testutil.Assert(t, r.matches(tcase.data))
+			c := r.correlation(tcase.data)
+			testutil.Assert(t, c.Error == nil, "unexpected error %v", c.Error)
+			testutil.Equals(t, tcase.expectedURL, c.URL)
+		})
+	}
+}
+
+func TestEmitter_Rules(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		rule     CorrelationRule
+		data     TemplateData
+		expected []string
+	}{
+		{
+			name:     "matching rule adds to built-in links",
+			rule:     CorrelationRule{Description: "Trace", Source: "jaeger", URL: "http://jaeger/trace/{{ .TraceID }}"},
+			data:     TemplateData{TraceID: "abc"},
+			expected: []string{"Built-in", "Trace"},
+		},
+		{
+			name:     "rule not matching keeps built-in links",
+			rule:     CorrelationRule{Description: "Trace", Source: "jaeger", When: CorrelationCondition{Exemplar: ExemplarFound}, URL: "http://jaeger/trace/{{ .TraceID }}"},
+			expected: []string{"Built-in"},
+		},
+		{
+			name:     "replace rule drops built-in links",
+			rule:     CorrelationRule{Description: "Profiles", Source: "parca", URL: "http://parca", Replace: true},
+			expected: []string{"Profiles"},
+		},
+		{
+			name:     "replace rule not matching drops built-in links",
+			rule:     CorrelationRule{Description: "Trace", Source: "jaeger", When: CorrelationCondition{Exemplar: ExemplarFound}, URL: "http://jaeger/trace/{{ .TraceID }}", Replace: true},
+			expected: nil,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			r, err := newCorrelationRule(tcase.rule)
+			testutil.Ok(t, err)
+			c := &Correlator{rules: []*correlationRule{r}}
+
+			var got []string
+			e := c.newEmitter(context.Background(), func(r Result) { got = append(got, r.Correlation.Description) })
+			e.correlation(Correlation{Description: "Built-in", Source: tcase.rule.Source}, nil)
+			e.rules(tcase.data)
+			e.wait()
+			testutil.Equals(t, tcase.expected, got)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package correlator
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// selector is a single series selector from the PromQL expression.
+type selector struct {
+	matchers []*labels.Matcher
+	// str is the PromQL representation of the selector, with sorted matchers.
+	str string
+}
+
+// extractSelectors returns all unique series selectors used in the given PromQL expression. Selectors targeting
+// the same series (e.g. the same selector used with different range, offset or in different order) are merged.
+func extractSelectors(query string) ([]selector, error) {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse %v", query)
+	}
+
+	var (
+		ret  []selector
+		seen = map[string]struct{}{}
+	)
+	for _, ms := range parser.ExtractSelectors(expr) {
+		s := newSelector(ms)
+		if _, ok := seen[s.str]; ok {
+			continue
+		}
+		seen[s.str] = struct{}{}
+		ret = append(ret, s)
+	}
+	if len(ret) == 0 {
+		return nil, errors.Wrapf(ErrNoSelectors, "query %v", query)
+	}
+	return ret, nil
+}
+
+func newSelector(ms []*labels.Matcher) selector {
+	sorted := make([]*labels.Matcher, len(ms))
+	copy(sorted, ms)
+	sort.Slice(sorted, func(i, j int) bool {
+		// Metric name first, so the selector reads naturally.
+		if (sorted[i].Name == labels.MetricName) != (sorted[j].Name == labels.MetricName) {
+			return sorted[i].Name == labels.MetricName
+		}
+		return sorted[i].String() < sorted[j].String()
+	})
+
+	name := ""
+	strs := make([]string, 0, len(sorted))
+	for _, m := range sorted {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual && name == "" {
+			name = m.Value
+			continue
+		}
+		strs = append(strs, m.String())
+	}
+	return selector{matchers: sorted, str: name + "{" + strings.Join(strs, ",") + "}"}
+}
+
+// alertValueQuery returns the alert expression without its top-level comparison with a number (threshold), so
+// it shows the value the alert fires on. Expression is returned as it is if it has no such comparison or can't be
+// parsed.
+func alertValueQuery(query string) string {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return query
+	}
+	b, ok := unwrapParens(expr).(*parser.BinaryExpr)
+	if !ok || !b.Op.IsComparisonOperator() || b.ReturnBool {
+		return query
+	}
+	if _, ok := unwrapParens(b.RHS).(*parser.NumberLiteral); ok {
+		return b.LHS.String()
+	}
+	if _, ok := unwrapParens(b.LHS).(*parser.NumberLiteral); ok {
+		return b.RHS.String()
+	}
+	return query
+}
+
+func unwrapParens(expr parser.Expr) parser.Expr {
+	for {
+		p, ok := expr.(*parser.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = p.Expr
+	}
+}
+
+// maxRange returns the longest range of range vector selectors and subqueries in the given PromQL expression.
+func maxRange(query string) (time.Duration, error) {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parse %v", query)
+	}
+
+	var r time.Duration
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		switch n := node.(type) {
+		case *parser.MatrixSelector:
+			if n.Range > r {
+				r = n.Range
+			}
+		case *parser.SubqueryExpr:
+			if n.Range > r {
+				r = n.Range
+			}
+		}
+		return nil
+	})
+	return r, nil
+}
+
+// commonLabels returns labels selected with equal matchers with the same value in all given selectors, except
+// metric name.
+func commonLabels(selectors []selector) model.LabelSet {
+	var ret model.LabelSet
+	for i, s := range selectors {
+		lset := model.LabelSet{}
+		for _, m := range s.matchers {
+			if m.Type != labels.MatchEqual || m.Name == labels.MetricName {
+				continue
+			}
+			if i == 0 || ret[model.LabelName(m.Name)] == model.LabelValue(m.Value) {
+				lset[model.LabelName(m.Name)] = model.LabelValue(m.Value)
+			}
+		}
+		ret = lset
+	}
+	return ret
+}
+
+// metricName returns the metric name of the selector, if it's selected with equal matcher.
+func (s selector) metricName() string {
+	for _, m := range s.matchers {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+			return m.Value
+		}
+	}
+	return ""
+}
+
+// viewQuery returns query suitable for viewing series of this selector, e.g. on a graph.
+// TODO(bwplotka): Create lib for building query?
+func (s selector) viewQuery() string {
+	if strings.HasSuffix(s.metricName(), "_total") {
+		return "rate(" + s.str + "[1m])"
+	}
+	return s.str
+}
@@ -0,0 +1,68 @@
+package correlator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/prometheus/common/model"
+)
+
+func TestExtractSelectors(t *testing.T) {
+	sels, err := extractSelectors(`sum(rate(http_requests_total{handler="/ping",code!~"2.."}[1m])) by (job) / sum(rate(http_requests_total{handler="/ping"}[1m])) by (job) > 0.3 and on (job) http_requests_total{code!~"2..",handler="/ping"} offset 5m`)
+	testutil.Ok(t, err)
+
+	var strs []string
+	for _, s := range sels {
+		strs = append(strs, s.str)
+	}
+	testutil.Equals(t, []string{
+		`http_requests_total{code!~"2..",handler="/ping"}`,
+		`http_requests_total{handler="/ping"}`,
+	}, strs)
+	testutil.Equals(t, `rate(http_requests_total{handler="/ping"}[1m])`, sels[1].viewQuery())
+
+	_, err = extractSelectors(`vector(1)`)
+	testutil.NotOk(t, err)
+}
+
+func TestAlertValueQuery(t *testing.T) {
+	for _, tcase := range []struct {
+		query, expected string
+	}{
+		{query: `sum(rate(http_requests_total{job="ping"}[1m])) > 0.3`, expected: `sum(rate(http_requests_total{job="ping"}[1m]))`},
+		{query: `(0.5 <= (up{job="ping"} / 2))`, expected: `(up{job="ping"} / 2)`},
+		{query: `up == bool 0`, expected: `up == bool 0`},
+		{query: `up{job="ping"} > on (job) up{job="pong"}`, expected: `up{job="ping"} > on (job) up{job="pong"}`},
+		{query: `rate(http_requests_total[1m]) > 0.3 and up == 1`, expected: `rate(http_requests_total[1m]) > 0.3 and up == 1`},
+		{query: `up{`, expected: `up{`},
+	} {
+		t.Run(tcase.query, func(t *testing.T) {
+			testutil.Equals(t, tcase.expected, alertValueQuery(tcase.query))
+		})
+	}
+}
+
+func TestMaxRange(t *testing.T) {
+	r, err := maxRange(`sum(rate(http_requests_total[1m])) / sum(rate(http_requests_total[5m])) > 0.3`)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 5*time.Minute, r)
+
+	r, err = maxRange(`max_over_time(rate(http_requests_total[1m])[1h:1m])`)
+	testutil.Ok(t, err)
+	testutil.Equals(t, time.Hour, r)
+
+	r, err = maxRange(`up == 0`)
+	testutil.Ok(t, err)
+	testutil.Equals(t, time.Duration(0), r)
+}
+
+func TestCommonLabels(t *testing.T) {
+	s, err := extractSelectors(`sum(rate(http_requests_total{job="ping",code=~"5..",handler="/ping"}[1m])) / sum(rate(http_requests_total{job="ping",handler="/"}[1m]))`)
+	testutil.Ok(t, err)
+	testutil.Equals(t, model.LabelSet{"job": "ping"}, commonLabels(s))
+
+	s, err = extractSelectors(`up{job="ping"} + up{job="pong"}`)
+	testutil.Ok(t, err)
+	testutil.Equals(t, model.LabelSet{}, commonLabels(s))
+}
@@ -0,0 +1,333 @@
+package correlator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// ErrNotFound is returned when requested data does not exist in the source.
+var ErrNotFound = errors.New("not found")
+
+// Source represents a single backend with observability data (e.g. Thanos, Loki, Jaeger, Parca).
+// Every Source has to implement at least one of signal specific interfaces (MetricsSource, LogsSource,
+// TracesSource, ProfilesSource) to be useful for correlations.
+type Source interface {
+	// Name returns unique name of the source, as configured.
+	Name() string
+	// Kind returns source type name the source was registered with e.g. "thanos".
+	Kind() string
+	// Healthy returns error if source is not reachable.
+	Healthy(ctx context.Context) error
+}
+
+// Scope describes what the correlation is about.
+type Scope struct {
+	// Labels identify the workload the correlation is about, e.g. alert or series labels.
+	Labels model.LabelSet
+	// TraceID is set if the correlation is about a single trace.
+	TraceID string
+	// Start and End represent the time window of the correlation.
+	Start, End time.Time
+	// Tenant is the tenant of the correlation, if any. Links should show data of this tenant only.
+	Tenant string
+}
+
+// MetricsSource is a Source that holds metrics, alerts and exemplars.
+type MetricsSource interface {
+	Source
+
+	// AlertingRules returns all alerting rules together with their alerts.
+	AlertingRules(ctx context.Context) ([]v1.AlertingRule, error)
+	// Exemplars returns exemplars for series matching given query.
+	Exemplars(ctx context.Context, query string, start, end time.Time) ([]v1.ExemplarQueryResult, error)
+	// TraceID returns normalized trace ID from exemplar labels or empty string if exemplar has no trace ID.
+	TraceID(exemplarLabels model.LabelSet) (string, error)
+	// REDQueries returns queries showing Rate, Errors and Duration of requests of the workload with the given labels.
+	REDQueries(lset model.LabelSet) []string
+	// MetricsURL returns link to the view showing given queries in the scope time window.
+	MetricsURL(scope Scope, queries ...string) string
+}
+
+// LogsSource is a Source that holds logs.
+type LogsSource interface {
+	Source
+
+	// LogsURL returns link to the view showing logs for the scope. If scope has trace ID, logs should be
+	// filtered by it.
+	LogsURL(scope Scope) string
+}
+
+// LogsVerifier is a LogsSource that can cheaply check if logs linked by LogsURL exist.
+type LogsVerifier interface {
+	LogsSource
+
+	// LogsExist returns true if there are any logs for the scope.
+	LogsExist(ctx context.Context, scope Scope) (bool, error)
+}
+
+// LogsFetcher is a LogsSource that can fetch logs.
+type LogsFetcher interface {
+	LogsSource
+
+	// Logs returns up to limit latest log lines for the scope. If scope has trace ID, lines with it come first
+	// with MatchesTrace set, followed by other lines for the scope.
+	Logs(ctx context.Context, scope Scope, limit int) ([]LogLine, error)
+}
+
+// TracesSource is a Source that holds traces.
+type TracesSource interface {
+	Source
+
+	// TraceURL returns link to the view of a single trace from the scope.
+	TraceURL(scope Scope) string
+	// TracesSearchURL returns link to the view showing traces for the scope.
+	TracesSearchURL(scope Scope) string
+}
+
+// TraceFetcher is a TracesSource that can fetch traces.
+type TraceFetcher interface {
+	TracesSource
+
+	// Trace returns the trace with the given ID. ErrNotFound is returned if the trace does not exist.
+	Trace(ctx context.Context, traceID string) (*Trace, error)
+}
+
+// TracesVerifier is a TracesSource that can cheaply check if traces linked by TracesSearchURL exist. Trace
+// linked by TraceURL is verified with TraceFetcher.
+type TracesVerifier interface {
+	TracesSource
+
+	// TracesExist returns true if there are any traces for the scope.
+	TracesExist(ctx context.Context, scope Scope) (bool, error)
+}
+
+// ProfilesSource is a Source that holds profiles.
+type ProfilesSource interface {
+	Source
+
+	// ProfilesURL returns link to the view showing profiles for the scope. If scope has trace ID, profiles
+	// should be filtered by it.
+	ProfilesURL(scope Scope) string
+}
+
+// ProfilesVerifier is a ProfilesSource that can cheaply check if profiles linked by ProfilesURL exist.
+type ProfilesVerifier interface {
+	ProfilesSource
+
+	// ProfilesExist returns true if there are any profiles for the scope.
+	ProfilesExist(ctx context.Context, scope Scope) (bool, error)
+}
+
+// ProfilesFetcher is a ProfilesSource that can summarize profiles.
+type ProfilesFetcher interface {
+	ProfilesSource
+
+	// TopFunctions returns up to limit functions with the highest flat and cumulative values in the profile
+	// merged from all profiles for the scope. Nil is returned if there are no profiles.
+	TopFunctions(ctx context.Context, scope Scope, limit int) (*ProfileSummary, error)
+}
+
+// SourceFactory creates new Source from the common source configuration and YAML encoded, type
+// specific configuration (SourceConfig.Config).
+type SourceFactory func(cfg SourceConfig, typeCfg []byte, logger log.Logger) (Source, error)
+
+var (
+	sourceTypesMu sync.RWMutex
+	sourceTypes   = map[string]SourceFactory{}
+)
+
+func init() {
+	RegisterSourceType("thanos", newThanosSource)
+	RegisterSourceType("loki", newLokiSource)
+	RegisterSourceType("jaeger", newJaegerSource)
+	RegisterSourceType("parca", newParcaSource)
+	RegisterSourceType("tempo", newTempoSource)
+	RegisterSourceType("zipkin", newZipkinSource)
+	RegisterSourceType("pyroscope", newPyroscopeSource)
+	RegisterSourceType("elasticsearch", newElasticsearchSource)
+}
+
+// RegisterSourceType registers source type, so it can be used in configuration. It panics if the
+// type was already registered.
+func RegisterSourceType(kind string, f SourceFactory) {
+	sourceTypesMu.Lock()
+	defer sourceTypesMu.Unlock()
+
+	if _, ok := sourceTypes[kind]; ok {
+		panic("correlator: source type registered twice: " + kind)
+	}
+	sourceTypes[kind] = f
+}
+
+// SourceTypes returns sorted names of all registered source types.
+func SourceTypes() []string {
+	sourceTypesMu.RLock()
+	defer sourceTypesMu.RUnlock()
+
+	kinds := make([]string, 0, len(sourceTypes))
+	for k := range sourceTypes {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func sourceFactory(kind string) (SourceFactory, bool) {
+	sourceTypesMu.RLock()
+	defer sourceTypesMu.RUnlock()
+
+	f, ok := sourceTypes[kind]
+	return f, ok
+}
+
+// NewSource creates Source for the given configuration using registered source types.
+func NewSource(cfg SourceConfig, logger log.Logger) (Source, error) {
+	f, ok := sourceFactory(cfg.Type)
+	if !ok {
+		return nil, errors.Errorf("unknown source type %q, supported: %v", cfg.Type, SourceTypes())
+	}
+	if cfg.Name == "" {
+		cfg.Name = cfg.Type
+	}
+
+	var typeCfg []byte
+	if cfg.Config != nil {
+		var err error
+		typeCfg, err = yaml.Marshal(cfg.Config)
+		if err != nil {
+			return nil, errors.Wrapf(err, "marshal config of source %v", cfg.Name)
+		}
+	}
+	s, err := f(cfg, typeCfg, log.With(logger, "source", cfg.Name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "create source %v", cfg.Name)
+	}
+	return s, nil
+}
+
+// baseSource implements common parts of the Source interface.
+type baseSource struct {
+	cfg    SourceConfig
+	client *http.Client
+}
+
+func newBaseSource(cfg SourceConfig) (baseSource, error) {
+	client, err := cfg.NewHTTPClient()
+	if err != nil {
+		return baseSource{}, errors.Wrap(err, "new HTTP client")
+	}
+	return baseSource{cfg: cfg, client: client}, nil
+}
+
+func (s baseSource) Name() string { return s.cfg.Name }
+func (s baseSource) Kind() string { return s.cfg.Type }
+
+func (s baseSource) scheme() string {
+	if s.cfg.Scheme == "" {
+		return "http"
+	}
+	return s.cfg.Scheme
+}
+
+func (s baseSource) internalURL(path string) string {
+	return s.scheme() + "://" + s.cfg.InternalEndpoint + path
+}
+
+// externalURL returns link to the path on ExternalEndpoint for the given tenant.
+func (s baseSource) externalURL(tenant, path string) string {
+	return s.tenantLinkURL(s.cfg.ExternalEndpoint, tenant, path)
+}
+
+// linkURL returns URL of the path on the given external endpoint, e.g. of Grafana. Source Scheme is used, unless
+// the endpoint has its own.
+func (s baseSource) linkURL(endpoint, path string) string {
+	if strings.Contains(endpoint, "://") {
+		return endpoint + path
+	}
+	return s.scheme() + "://" + endpoint + path
+}
+
+// healthy checks if GET request to the given internal path returns 2xx status code.
+func (s baseSource) healthy(ctx context.Context, path string) error {
+	return s.get(ctx, path, nil)
+}
+
+// get sends GET request to the given internal path (with query if any) and decodes JSON response into v,
+// unless v is nil. Error is returned for non 2xx status codes.
+func (s baseSource) get(ctx context.Context, path string, v interface{}) error {
+	return s.do(ctx, http.MethodGet, path, nil, v)
+}
+
+// post is like get, but it sends POST request with the given body encoded as JSON.
+func (s baseSource) post(ctx context.Context, path string, body interface{}, v interface{}) error {
+	return s.do(ctx, http.MethodPost, path, body, v)
+}
+
+func (s baseSource) do(ctx context.Context, method string, path string, body interface{}, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrapf(err, "%v source: encode request body", s.Name())
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.internalURL(path), r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "%v source", s.Name())
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.Wrapf(statusError{code: resp.StatusCode, body: string(b)}, "%v source: %v %v", s.Name(), method, path)
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "%v source: decode response of %v %v", s.Name(), method, path)
+	}
+	return nil
+}
+
+// statusError is returned when source responds with unexpected HTTP status code.
+type statusError struct {
+	code int
+	body string
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.code, strings.TrimSpace(e.body))
+}
+
+// isNotFound returns true if the error was caused by the 404 Not Found response.
+func isNotFound(err error) bool {
+	se, ok := errors.Cause(err).(statusError)
+	return ok && se.code == http.StatusNotFound
+}
@@ -0,0 +1,291 @@
+package correlator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+)
+
+func TestNewSource_HTTPClientConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/api/v1/rules" {
+			_, _ = w.Write([]byte(`{"status":"success","data":{"groups":[]}}`))
+		}
+	}))
+	defer srv.Close()
+
+	cfg, err := ParseConfig([]byte(`
+sources:
+- type: thanos
+  internalEndpoint: ` + strings.TrimPrefix(srv.URL, "http://") + `
+  httpClientConfig:
+    authorization:
+      credentials: secret
+- type: loki
+  internalEndpoint: ` + strings.TrimPrefix(srv.URL, "http://") + `
+  config:
+    grafanaExternalEndpoint: localhost:3000
+`))
+	testutil.Ok(t, err)
+
+	thanos, err := NewSource(cfg.Sources[0], log.NewNopLogger())
+	testutil.Ok(t, err)
+	testutil.Ok(t, thanos.Healthy(context.Background()))
+	_, err = thanos.(MetricsSource).AlertingRules(context.Background())
+	testutil.Ok(t, err)
+
+	loki, err := NewSource(cfg.Sources[1], log.NewNopLogger())
+	testutil.Ok(t, err)
+	testutil.NotOk(t, loki.Healthy(context.Background()))
+
+	_, err = ParseConfig([]byte(`
+sources:
+- type: thanos
+  httpClientConfig:
+    bearer_token: secret
+    bearer_token_file: /etc/token
+`))
+	testutil.NotOk(t, err)
+
+	_, err = ParseConfig([]byte(`
+sources:
+- type: thanos
+  scheme: ftp
+`))
+	testutil.NotOk(t, err)
+}
+
+func TestNewSource_Tenancy(t *testing.T) {
+	var gotPath, gotTenant string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotTenant = r.URL.Path, r.Header.Get("X-Scope-OrgID")
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[]}}`))
+	}))
+	defer srv.Close()
+
+	s, err := NewSource(SourceConfig{
+		Name:             "loki",
+		Type:             "loki",
+		InternalEndpoint: strings.TrimPrefix(srv.URL, "http://"),
+		Tenancy: TenancyConfig{
+			Header:        "X-Scope-OrgID",
+			PathPrefix:    "/api/logs/v1/{tenant}",
+			GrafanaOrgIDs: map[string]int64{"team-a": 2},
+		},
+		Config: LokiConfig{GrafanaExternalEndpoint: "localhost:3000"},
+	}, log.NewNopLogger())
+	testutil.Ok(t, err)
+	logs := s.(LogsVerifier)
+
+	scope := Scope{Labels: model.LabelSet{"job": "ping"}, Start: time.Unix(1650000000, 0), End: time.Unix(1650003600, 0)}
+	_, err = logs.LogsExist(context.Background(), scope)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "/loki/api/v1/query_range", gotPath)
+	testutil.Equals(t, "", gotTenant)
+	testutil.Assert(t, strings.Contains(logs.LogsURL(scope), "orgId=1&"), logs.LogsURL(scope))
+
+	scope.Tenant = "team-a"
+	_, err = logs.LogsExist(WithTenant(context.Background(), scope.Tenant), scope)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "/api/logs/v1/team-a/loki/api/v1/query_range", gotPath)
+	testutil.Equals(t, "team-a", gotTenant)
+	testutil.Assert(t, strings.Contains(logs.LogsURL(scope), "orgId=2&"), logs.LogsURL(scope))
+}
+
+func TestNewSource_Scheme(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	cfg, err := ParseConfig([]byte(`
+sources:
+- type: thanos
+  scheme: https
+  internalEndpoint: ` + strings.TrimPrefix(srv.URL, "https://") + `
+  externalEndpoint: thanos.example.com
+  httpClientConfig:
+    tls_config:
+      insecure_skip_verify: true
+- type: loki
+  scheme: https
+  internalEndpoint: loki:3100
+  config:
+    grafanaExternalEndpoint: grafana.example.com
+- name: loki-http-grafana
+  type: loki
+  scheme: https
+  internalEndpoint: loki:3100
+  config:
+    grafanaExternalEndpoint: http://grafana:3000
+`))
+	testutil.Ok(t, err)
+
+	scope := Scope{Labels: model.LabelSet{"job": "ping"}, Start: time.Unix(1650000000, 0), End: time.Unix(1650003600, 0)}
+	thanos, err := NewSource(cfg.Sources[0], log.NewNopLogger())
+	testutil.Ok(t, err)
+	testutil.Ok(t, thanos.Healthy(context.Background()))
+	u := thanos.(MetricsSource).MetricsURL(scope, "up")
+	testutil.Assert(t, strings.HasPrefix(u, "https://thanos.example.com/graph?"), u)
+
+	loki, err := NewSource(cfg.Sources[1], log.NewNopLogger())
+	testutil.Ok(t, err)
+	u = loki.(LogsSource).LogsURL(scope)
+	testutil.Assert(t, strings.HasPrefix(u, "https://grafana.example.com/explore?"), u)
+
+	loki, err = NewSource(cfg.Sources[2], log.NewNopLogger())
+	testutil.Ok(t, err)
+	u = loki.(LogsSource).LogsURL(scope)
+	testutil.Assert(t, strings.HasPrefix(u, "http://grafana:3000/explore?"), u)
+}
+
+func TestNewSource_TenantLinks(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+sources:
+- type: thanos
+  externalEndpoint: thanos:9090
+  tenancy:
+    linkQueryParam: tenant
+- type: loki
+  config:
+    grafanaExternalEndpoint: grafana:3000
+  tenancy:
+    linkPathPrefix: /ignored/{tenant}
+    grafanaOrgIDs:
+      team-a: 2
+- type: elasticsearch
+  config:
+    index: logs-*
+    dashboardsExternalEndpoint: kibana:5601
+  tenancy:
+    linkPathPrefix: /s/{tenant}
+    linkQueryParam: tenant
+- type: jaeger
+  externalEndpoint: jaeger:16686
+  tenancy:
+    linkPathPrefix: /{tenant}/
+- name: tempo-grafana
+  type: tempo
+  config:
+    grafanaExternalEndpoint: grafana:3000
+  tenancy:
+    grafanaOrgIDs:
+      team-a: 2
+- type: tempo
+  externalEndpoint: tempo:3200
+  tenancy:
+    linkPathPrefix: /api/traces/v1/{tenant}
+- type: zipkin
+  externalEndpoint: zipkin:9411
+  tenancy:
+    linkQueryParam: tenant
+- type: parca
+  externalEndpoint: parca:7070
+  tenancy:
+    linkQueryParam: tenant
+- type: pyroscope
+  externalEndpoint: pyroscope:4040
+  tenancy:
+    linkPathPrefix: /{tenant}
+`))
+	testutil.Ok(t, err)
+
+	sources := map[string]Source{}
+	for _, c := range cfg.Sources {
+		s, err := NewSource(c, log.NewNopLogger())
+		testutil.Ok(t, err)
+		sources[s.Name()] = s
+	}
+
+	scope := Scope{
+		Labels:  model.LabelSet{"job": "ping"},
+		TraceID: "a8d0e79cbdfc13e4a8d0e79cbdfc13e4",
+		Start:   time.Unix(1650000000, 0),
+		End:     time.Unix(1650003600, 0),
+	}
+	for _, tcase := range []struct {
+		name string
+		link func(scope Scope) string
+		// Links without tenant start with noTenant, links of tenant team-a start with tenant and end with
+		// tenantSuffix. Grafana links carry the tenant only as organization ID.
+		noTenant, tenant string
+		tenantSuffix     string
+	}{
+		{
+			name:     "thanos",
+			link:     func(scope Scope) string { return sources["thanos"].(MetricsSource).MetricsURL(scope, "up") },
+			noTenant: "http://thanos:9090/graph?g0.end_input=",
+			tenant:   "http://thanos:9090/graph?g0.end_input=2022-04-15+06%3A20%3A00&g0.expr=up&g0.max_source_resolution=0s&g0.range_input=1h&g0.stacked=0&g0.tab=0&tenant=team-a",
+		},
+		{
+			name:     "loki",
+			link:     func(scope Scope) string { return sources["loki"].(LogsSource).LogsURL(scope) },
+			noTenant: "http://grafana:3000/explore?orgId=1&left=",
+			tenant:   "http://grafana:3000/explore?orgId=2&left=",
+		},
+		{
+			name:     "elasticsearch",
+			link:     func(scope Scope) string { return sources["elasticsearch"].(LogsSource).LogsURL(scope) },
+			noTenant: "http://kibana:5601/app/discover#/?_g=",
+			tenant:   "http://kibana:5601/s/team-a/app/discover?tenant=team-a#/?_g=",
+		},
+		{
+			name:     "jaeger",
+			link:     func(scope Scope) string { return sources["jaeger"].(TracesSource).TraceURL(scope) },
+			noTenant: "http://jaeger:16686/trace/a8d0e79cbdfc13e4a8d0e79cbdfc13e4",
+			tenant:   "http://jaeger:16686/team-a/trace/a8d0e79cbdfc13e4a8d0e79cbdfc13e4",
+		},
+		{
+			name:     "tempo-grafana",
+			link:     func(scope Scope) string { return sources["tempo-grafana"].(TracesSource).TraceURL(scope) },
+			noTenant: "http://grafana:3000/explore?orgId=1&left=",
+			tenant:   "http://grafana:3000/explore?orgId=2&left=",
+		},
+		{
+			name:     "tempo",
+			link:     func(scope Scope) string { return sources["tempo"].(TracesSource).TraceURL(scope) },
+			noTenant: "http://tempo:3200/api/traces/a8d0e79cbdfc13e4a8d0e79cbdfc13e4",
+			tenant:   "http://tempo:3200/api/traces/v1/team-a/api/traces/a8d0e79cbdfc13e4a8d0e79cbdfc13e4",
+		},
+		{
+			name:     "zipkin",
+			link:     func(scope Scope) string { return sources["zipkin"].(TracesSource).TraceURL(scope) },
+			noTenant: "http://zipkin:9411/zipkin/traces/a8d0e79cbdfc13e4a8d0e79cbdfc13e4",
+			tenant:   "http://zipkin:9411/zipkin/traces/a8d0e79cbdfc13e4a8d0e79cbdfc13e4?tenant=team-a",
+		},
+		{
+			name:         "parca",
+			link:         func(scope Scope) string { return sources["parca"].(ProfilesSource).ProfilesURL(scope) },
+			noTenant:     "http://parca:7070/?currentProfileView=icicle&expression_a=",
+			tenant:       "http://parca:7070/?currentProfileView=icicle&expression_a=",
+			tenantSuffix: "&to_a=1650003600000&tenant=team-a",
+		},
+		{
+			name:     "pyroscope",
+			link:     func(scope Scope) string { return sources["pyroscope"].(ProfilesSource).ProfilesURL(scope) },
+			noTenant: "http://pyroscope:4040/?",
+			tenant:   "http://pyroscope:4040/team-a/?",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			scope := scope
+			u := tcase.link(scope)
+			testutil.Assert(t, strings.HasPrefix(u, tcase.noTenant), u)
+			testutil.Assert(t, !strings.Contains(u, "team-a"), u)
+
+			scope.Tenant = "team-a"
+			u = tcase.link(scope)
+			testutil.Assert(t, strings.HasPrefix(u, tcase.tenant), u)
+			testutil.Assert(t, strings.HasSuffix(u, tcase.tenantSuffix), u)
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package correlator
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+)
+
+// TempoConfig is a "tempo" source type specific configuration.
+type TempoConfig struct {
+	// GrafanaExternalEndpoint is an endpoint of Grafana used for viewing traces in Explore. If empty, links
+	// point to Tempo HTTP API on the source ExternalEndpoint.
+	GrafanaExternalEndpoint string `json:",omitempty"`
+	// GrafanaDatasource is a name of Tempo datasource in Grafana. Defaults to "Tempo".
+	GrafanaDatasource string `json:",omitempty"`
+}
+
+type tempoSource struct {
+	baseSource
+
+	cfg TempoConfig
+}
+
+func newTempoSource(cfg SourceConfig, typeCfg []byte, _ log.Logger) (Source, error) {
+	b, err := newBaseSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &tempoSource{baseSource: b}
+	if err := yaml.Unmarshal(typeCfg, &s.cfg); err != nil {
+		return nil, errors.Wrap(err, "parse Tempo config")
+	}
+	if s.cfg.GrafanaDatasource == "" {
+		s.cfg.GrafanaDatasource = "Tempo"
+	}
+	return s, nil
+}
+
+func (s *tempoSource) Healthy(ctx context.Context) error {
+	return s.healthy(ctx, "/ready")
+}
+
+// service returns service name to search traces for, empty if scope does not identify one.
+func (s *tempoSource) service(scope Scope) string {
+	if service := scope.Labels["service"]; service != "" {
+		return string(service)
+	}
+	return string(scope.Labels["job"])
+}
+
+// exploreURL returns link to Grafana Explore with the given TraceQL query (or trace ID) in the scope time window.
+func (s *tempoSource) exploreURL(scope Scope, query string) string {
+	left, _ := json.Marshal([]interface{}{
+		strconv.FormatInt(unixMillis(scope.Start), 10),
+		strconv.FormatInt(unixMillis(scope.End), 10),
+		s.cfg.GrafanaDatasource,
+		map[string]string{"refId": "A", "queryType": "traceql", "query": query},
+	})
+	return s.linkURL(s.cfg.GrafanaExternalEndpoint, "/explore?orgId="+s.grafanaOrgID(scope.Tenant)+"&left="+url.QueryEscape(string(left)))
+}
+
+func (s *tempoSource) TraceURL(scope Scope) string {
+	if s.cfg.GrafanaExternalEndpoint != "" {
+		return s.exploreURL(scope, scope.TraceID)
+	}
+	return s.externalURL(scope.Tenant, "/api/traces/"+url.PathEscape(scope.TraceID))
+}
+
+func (s *tempoSource) TracesSearchURL(scope Scope) string {
+	if s.cfg.GrafanaExternalEndpoint != "" {
+		query := "{}"
+		if service := s.service(scope); service != "" {
+			query = fmt.Sprintf("{resource.service.name=%s}", strconv.Quote(service))
+		}
+		return s.exploreURL(scope, query)
+	}
+	v := s.searchParams(scope)
+	v.Set("limit", "20")
+	return s.externalURL(scope.Tenant, "/api/search?"+v.Encode())
+}
+
+// searchParams returns parameters of Tempo search API for the scope.
+func (s *tempoSource) searchParams(scope Scope) url.Values {
+	v := url.Values{}
+	if service := s.service(scope); service != "" {
+		v.Set("tags", "service.name="+service)
+	}
+	// Tempo expects seconds.
+	v.Set("start", strconv.FormatInt(scope.Start.Unix(), 10))
+	v.Set("end", strconv.FormatInt(scope.End.Unix(), 10))
+	return v
+}
+
+func (s *tempoSource) TracesExist(ctx context.Context, scope Scope) (bool, error) {
+	v := s.searchParams(scope)
+	v.Set("limit", "1")
+
+	var resp struct {
+		Traces []json.RawMessage `json:"traces"`
+	}
+	if err := s.get(ctx, "/api/search?"+v.Encode(), &resp); err != nil {
+		return false, err
+	}
+	return len(resp.Traces) > 0, nil
+}
+
+// otlpAnyValue is OTLP attribute value in protobuf JSON format.
+type otlpAnyValue struct {
+	StringValue *string      `json:"stringValue"`
+	IntValue    *json.Number `json:"intValue"`
+	DoubleValue *json.Number `json:"doubleValue"`
+	BoolValue   *bool        `json:"boolValue"`
+}
+
+func (v otlpAnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		return v.IntValue.String()
+	case v.DoubleValue != nil:
+		return v.DoubleValue.String()
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	}
+	return ""
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+func otlpAttributes(kvs []otlpKeyValue) map[string]string {
+	if len(kvs) == 0 {
+		return nil
+	}
+	ret := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		ret[kv.Key] = kv.Value.String()
+	}
+	return ret
+}
+
+type otlpSpans struct {
+	Spans []struct {
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId"`
+		Name              string         `json:"name"`
+		StartTimeUnixNano json.Number    `json:"startTimeUnixNano"`
+		EndTimeUnixNano   json.Number    `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes"`
+		Status            struct {
+			// Code is either enum name or its number.
+			Code    interface{} `json:"code"`
+			Message string      `json:"message"`
+		} `json:"status"`
+	} `json:"spans"`
+}
+
+// tempoTraceResponse is the OTLP trace in protobuf JSON format, as returned by Tempo.
+type tempoTraceResponse struct {
+	Batches []struct {
+		Resource struct {
+			Attributes []otlpKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []otlpSpans `json:"scopeSpans"`
+		// InstrumentationLibrarySpans is used by older Tempo versions instead of ScopeSpans.
+		InstrumentationLibrarySpans []otlpSpans `json:"instrumentationLibrarySpans"`
+	} `json:"batches"`
+}
+
+// otlpID converts base64 encoded OTLP span ID to hex. IDs already in hex are returned as they are.
+func otlpID(id string) string {
+	if b, err := base64.StdEncoding.DecodeString(id); err == nil && len(b) == 8 {
+		return hex.EncodeToString(b)
+	}
+	return id
+}
+
+func (s *tempoSource) Trace(ctx context.Context, traceID string) (*Trace, error) {
+	var resp tempoTraceResponse
+	if err := s.get(ctx, "/api/traces/"+url.PathEscape(traceID), &resp); err != nil {
+		if isNotFound(err) {
+			return nil, errors.Wrapf(ErrNotFound, "%v source: trace %v", s.Name(), traceID)
+		}
+		return nil, err
+	}
+
+	t := &Trace{TraceID: traceID}
+	for _, b := range resp.Batches {
+		resource := otlpAttributes(b.Resource.Attributes)
+		for _, ss := range append(b.ScopeSpans, b.InstrumentationLibrarySpans...) {
+			for _, sp := range ss.Spans {
+				start, err := sp.StartTimeUnixNano.Int64()
+				if err != nil {
+					return nil, errors.Wrapf(err, "%v source: parse start time of span %v", s.Name(), sp.SpanID)
+				}
+				end, err := sp.EndTimeUnixNano.Int64()
+				if err != nil {
+					return nil, errors.Wrapf(err, "%v source: parse end time of span %v", s.Name(), sp.SpanID)
+				}
+
+				span := Span{
+					SpanID:        otlpID(sp.SpanID),
+					OperationName: sp.Name,
+					Service:       resource["service.name"],
+					Start:         time.Unix(0, start),
+					Duration:      time.Duration(end - start),
+					Tags:          otlpAttributes(sp.Attributes),
+					Resource:      resource,
+				}
+				if sp.ParentSpanID != "" {
+					span.ParentSpanID = otlpID(sp.ParentSpanID)
+				}
+				switch sp.Status.Code {
+				case "STATUS_CODE_ERROR", float64(2):
+					if span.Tags == nil {
+						span.Tags = map[string]string{}
+					}
+					span.Tags["otel.status_code"] = "ERROR"
+					if sp.Status.Message != "" {
+						span.Tags["otel.status_description"] = sp.Status.Message
+					}
+				}
+				t.Spans = append(t.Spans, span)
+			}
+		}
+	}
+	if len(t.Spans) == 0 {
+		return nil, errors.Wrapf(ErrNotFound, "%v source: trace %v", s.Name(), traceID)
+	}
+	return t, nil
+}
@@ -0,0 +1,79 @@
+package correlator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// Span IDs are base64 encoded b7ad6b7169203331 and 00f067aa0ba902b7.
+const tempoTestTrace = `{"batches":[{
+"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"demo:ping"}},{"key":"host.name","value":{"stringValue":"ping-1"}}]},
+"scopeSpans":[{"spans":[
+{"traceId":"DYmuTEc4Ysqo0OecvfwT5A==","spanId":"t61rcWkgMzE=","name":"/ping","startTimeUnixNano":"1650000000000000000","endTimeUnixNano":"1650000000020000000",
+ "attributes":[{"key":"http.status_code","value":{"intValue":"500"}}]},
+{"traceId":"DYmuTEc4Ysqo0OecvfwT5A==","spanId":"APBnqgupArc=","parentSpanId":"t61rcWkgMzE=","name":"db","startTimeUnixNano":"1650000000005000000","endTimeUnixNano":"1650000000035000000",
+ "status":{"code":"STATUS_CODE_ERROR","message":"connection refused"}}
+]}]}]}`
+
+func TestTempoSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/traces/0d89ae4c473862caa8d0e79cbdfc13e4":
+			_, _ = w.Write([]byte(tempoTestTrace))
+		case "/api/search":
+			if r.URL.Query().Get("tags") == "service.name=demo:ping" {
+				_, _ = w.Write([]byte(`{"traces":[{"traceID":"0d89ae4c473862caa8d0e79cbdfc13e4","rootServiceName":"demo:ping"}]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"traces":[]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := newTempoSource(SourceConfig{Name: "tempo", Type: "tempo", InternalEndpoint: strings.TrimPrefix(srv.URL, "http://"), ExternalEndpoint: "localhost:3200"}, nil, log.NewNopLogger())
+	testutil.Ok(t, err)
+
+	t.Run("trace", func(t *testing.T) {
+		trace, err := s.(TraceFetcher).Trace(context.Background(), "0d89ae4c473862caa8d0e79cbdfc13e4")
+		testutil.Ok(t, err)
+		testutil.Equals(t, TraceSummary{
+			TraceID:     "0d89ae4c473862caa8d0e79cbdfc13e4",
+			Duration:    35 * time.Millisecond,
+			SpanCount:   2,
+			Services:    []string{"demo:ping"},
+			ErrorSpans:  []SpanSummary{{SpanID: "00f067aa0ba902b7", Service: "demo:ping", OperationName: "db", Duration: 30 * time.Millisecond, Tags: map[string]string{"otel.status_code": "ERROR", "otel.status_description": "connection refused"}}},
+			SlowestSpan: &SpanSummary{SpanID: "00f067aa0ba902b7", Service: "demo:ping", OperationName: "db", Duration: 30 * time.Millisecond, Tags: map[string]string{"otel.status_code": "ERROR", "otel.status_description": "connection refused"}},
+		}, trace.Summary())
+
+		root := trace.Root()
+		testutil.Equals(t, "b7ad6b7169203331", root.SpanID)
+		testutil.Equals(t, map[string]string{"http.status_code": "500"}, root.Tags)
+		testutil.Equals(t, map[string]string{"service.name": "demo:ping", "host.name": "ping-1"}, root.Resource)
+
+		_, err = s.(TraceFetcher).Trace(context.Background(), "a8d0e79cbdfc13e4")
+		testutil.Equals(t, ErrNotFound, errors.Cause(err))
+	})
+	t.Run("search", func(t *testing.T) {
+		scope := Scope{Labels: model.LabelSet{"service": "demo:ping"}, Start: time.Unix(1650000000, 0), End: time.Unix(1650003600, 0)}
+		ok, err := s.(TracesVerifier).TracesExist(context.Background(), scope)
+		testutil.Ok(t, err)
+		testutil.Assert(t, ok)
+		testutil.Equals(t, "http://localhost:3200/api/search?end=1650003600&limit=20&start=1650000000&tags=service.name%3Ddemo%3Aping", s.(TracesSource).TracesSearchURL(scope))
+
+		scope.Labels = model.LabelSet{"service": "demo:pong"}
+		ok, err = s.(TracesVerifier).TracesExist(context.Background(), scope)
+		testutil.Ok(t, err)
+		testutil.Assert(t, !ok)
+	})
+}
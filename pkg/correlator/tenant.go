@@ -0,0 +1,84 @@
+package correlator
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns context carrying the tenant of the correlation, passed to sources by HTTP clients created
+// with SourceConfig.NewHTTPClient.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	if tenant == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant of the correlation the context belongs to, empty if none.
+func TenantFromContext(ctx context.Context) string {
+	t, _ := ctx.Value(tenantKey{}).(string)
+	return t
+}
+
+// tenantRoundTripper passes the tenant from request context to the source as configured by TenancyConfig.
+type tenantRoundTripper struct {
+	cfg  TenancyConfig
+	next http.RoundTripper
+}
+
+func (rt *tenantRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	tenant := TenantFromContext(req.Context())
+	if tenant == "" {
+		return rt.next.RoundTrip(req)
+	}
+
+	// RoundTripper must not modify the given request.
+	req = req.Clone(req.Context())
+	if rt.cfg.Header != "" {
+		req.Header.Set(rt.cfg.Header, tenant)
+	}
+	if rt.cfg.PathPrefix != "" {
+		prefix := strings.TrimSuffix(strings.ReplaceAll(rt.cfg.PathPrefix, "{tenant}", tenant), "/")
+		req.URL.Path = prefix + req.URL.Path
+		if req.URL.RawPath != "" {
+			req.URL.RawPath = prefix + req.URL.RawPath
+		}
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// tenantLinkURL returns URL of the path on the given external endpoint with the tenant applied as configured by
+// LinkPathPrefix and LinkQueryParam. Query parameter is added before URL fragment, if any.
+func (s baseSource) tenantLinkURL(endpoint, tenant, path string) string {
+	if tenant == "" {
+		return s.linkURL(endpoint, path)
+	}
+	if s.cfg.Tenancy.LinkPathPrefix != "" {
+		path = strings.TrimSuffix(strings.ReplaceAll(s.cfg.Tenancy.LinkPathPrefix, "{tenant}", url.PathEscape(tenant)), "/") + path
+	}
+	if s.cfg.Tenancy.LinkQueryParam != "" {
+		fragment := ""
+		if i := strings.Index(path, "#"); i >= 0 {
+			path, fragment = path[:i], path[i:]
+		}
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		path += sep + url.QueryEscape(s.cfg.Tenancy.LinkQueryParam) + "=" + url.QueryEscape(tenant) + fragment
+	}
+	return s.linkURL(endpoint, path)
+}
+
+// grafanaOrgID returns ID of the Grafana organization of the tenant, for links to Grafana.
+func (s baseSource) grafanaOrgID(tenant string) string {
+	if id, ok := s.cfg.Tenancy.GrafanaOrgIDs[tenant]; ok {
+		return strconv.FormatInt(id, 10)
+	}
+	return "1"
+}
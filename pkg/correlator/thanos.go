@@ -0,0 +1,155 @@
+package correlator
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// ThanosConfig is a "thanos" source type specific configuration.
+type ThanosConfig struct {
+	// TraceID specifies how to get trace ID from exemplars.
+	TraceID TraceIDConfig
+	// RED specifies metrics used to show Rate, Errors and Duration of requests of the workload.
+	RED REDConfig
+}
+
+// REDConfig specifies metrics used for Rate, Errors and Duration queries.
+type REDConfig struct {
+	// RequestsMetric is a name of the counter of handled requests. Defaults to "http_requests_total".
+	RequestsMetric string `json:",omitempty"`
+	// ErrorsMatcher is a matcher selecting failed requests from RequestsMetric. Defaults to `code=~"5.."`.
+	ErrorsMatcher string `json:",omitempty"`
+	// DurationMetric is a name of the histogram of request durations, without "_bucket" suffix.
+	// Defaults to "http_request_duration_seconds".
+	DurationMetric string `json:",omitempty"`
+}
+
+func (c *REDConfig) validate() error {
+	if c.RequestsMetric == "" {
+		c.RequestsMetric = "http_requests_total"
+	}
+	if c.ErrorsMatcher == "" {
+		c.ErrorsMatcher = `code=~"5.."`
+	}
+	if c.DurationMetric == "" {
+		c.DurationMetric = "http_request_duration_seconds"
+	}
+	if _, err := parser.ParseMetricSelector("{" + c.ErrorsMatcher + "}"); err != nil {
+		return errors.Wrap(err, "parse RED ErrorsMatcher")
+	}
+	return nil
+}
+
+type thanosSource struct {
+	baseSource
+
+	cfg ThanosConfig
+	api v1.API
+}
+
+func newThanosSource(cfg SourceConfig, typeCfg []byte, _ log.Logger) (Source, error) {
+	b, err := newBaseSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &thanosSource{baseSource: b}
+	if err := yaml.Unmarshal(typeCfg, &s.cfg); err != nil {
+		return nil, errors.Wrap(err, "parse Thanos config")
+	}
+	if err := s.cfg.TraceID.validate(); err != nil {
+		return nil, err
+	}
+	if err := s.cfg.RED.validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := api.NewClient(api.Config{Address: s.internalURL(""), RoundTripper: s.client.Transport})
+	if err != nil {
+		return nil, errors.Wrap(err, "new Thanos HTTP client")
+	}
+	s.api = v1.NewAPI(client)
+	return s, nil
+}
+
+func (s *thanosSource) Healthy(ctx context.Context) error {
+	return s.healthy(ctx, "/-/healthy")
+}
+
+func (s *thanosSource) AlertingRules(ctx context.Context) ([]v1.AlertingRule, error) {
+	rules, err := s.api.Rules(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "rules")
+	}
+
+	var ret []v1.AlertingRule
+	for _, g := range rules.Groups {
+		for _, r := range g.Rules {
+			if v, ok := r.(v1.AlertingRule); ok {
+				ret = append(ret, v)
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (s *thanosSource) Exemplars(ctx context.Context, query string, start, end time.Time) ([]v1.ExemplarQueryResult, error) {
+	res, err := s.api.QueryExemplars(ctx, query, start, end)
+	if err != nil {
+		return nil, errors.Wrap(err, "exemplars")
+	}
+	return res, nil
+}
+
+func (s *thanosSource) TraceID(exemplarLabels model.LabelSet) (string, error) {
+	return s.cfg.TraceID.traceID(exemplarLabels)
+}
+
+func (s *thanosSource) REDQueries(lset model.LabelSet) []string {
+	names := make(model.LabelNames, 0, len(lset))
+	for n := range lset {
+		names = append(names, n)
+	}
+	sort.Sort(names)
+
+	matchers := make([]string, 0, len(names))
+	for _, n := range names {
+		matchers = append(matchers, fmt.Sprintf("%s=%q", n, lset[n]))
+	}
+	sel := strings.Join(matchers, ",")
+	errSel := s.cfg.RED.ErrorsMatcher
+	if sel != "" {
+		errSel = sel + "," + errSel
+	}
+	return []string{
+		fmt.Sprintf("sum(rate(%s{%s}[1m]))", s.cfg.RED.RequestsMetric, sel),
+		fmt.Sprintf("sum(rate(%s{%s}[1m]))", s.cfg.RED.RequestsMetric, errSel),
+		fmt.Sprintf("histogram_quantile(0.99, sum by (le) (rate(%s_bucket{%s}[1m])))", s.cfg.RED.DurationMetric, sel),
+	}
+}
+
+func (s *thanosSource) MetricsURL(scope Scope, queries ...string) string {
+	v := url.Values{}
+	for i, q := range queries {
+		g := "g" + strconv.Itoa(i) + "."
+		v.Set(g+"expr", q)
+		v.Set(g+"tab", "0")
+		v.Set(g+"stacked", "0")
+		v.Set(g+"range_input", model.Duration(scope.End.Sub(scope.Start).Truncate(time.Second)).String())
+		v.Set(g+"end_input", scope.End.UTC().Format("2006-01-02 15:04:05"))
+		v.Set(g+"max_source_resolution", "0s")
+	}
+	return s.externalURL(scope.Tenant, "/graph?"+v.Encode())
+}
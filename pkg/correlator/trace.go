@@ -0,0 +1,124 @@
+package correlator
+
+import (
+	"sort"
+	"time"
+)
+
+// Trace is a source agnostic representation of the distributed trace.
+type Trace struct {
+	TraceID string
+	Spans   []Span
+}
+
+// Span is a single span of the trace.
+type Span struct {
+	SpanID        string
+	ParentSpanID  string `json:",omitempty"`
+	OperationName string
+	// Service is the name of the service that produced the span.
+	Service  string
+	Start    time.Time
+	Duration time.Duration
+	Tags     map[string]string `json:",omitempty"`
+	// Resource holds attributes of the process that produced the span, e.g. hostname or container.
+	Resource map[string]string `json:",omitempty"`
+}
+
+// Root returns the root span of the trace, or the earliest span if root is missing. Nil is returned for trace
+// without spans.
+func (t *Trace) Root() *Span {
+	var root *Span
+	for i := range t.Spans {
+		s := &t.Spans[i]
+		if s.ParentSpanID == "" {
+			return s
+		}
+		if root == nil || s.Start.Before(root.Start) {
+			root = s
+		}
+	}
+	return root
+}
+
+// Start returns the start time of the earliest span.
+func (t *Trace) Start() time.Time {
+	var start time.Time
+	for _, s := range t.Spans {
+		if start.IsZero() || s.Start.Before(start) {
+			start = s.Start
+		}
+	}
+	return start
+}
+
+// End returns the end time of the latest span.
+func (t *Trace) End() time.Time {
+	var end time.Time
+	for _, s := range t.Spans {
+		if e := s.Start.Add(s.Duration); e.After(end) {
+			end = e
+		}
+	}
+	return end
+}
+
+// TraceSummary is a short summary of the trace, telling what happened without opening it.
+type TraceSummary struct {
+	TraceID   string
+	Duration  time.Duration
+	SpanCount int
+	// Services are names of all services the trace went through, sorted.
+	Services []string
+	// ErrorSpans are spans marked as failed with "error" or "otel.status_code" tags.
+	ErrorSpans  []SpanSummary `json:",omitempty"`
+	SlowestSpan *SpanSummary  `json:",omitempty"`
+}
+
+// SpanSummary is a short summary of the span.
+type SpanSummary struct {
+	SpanID        string
+	Service       string
+	OperationName string
+	Duration      time.Duration
+	Tags          map[string]string `json:",omitempty"`
+}
+
+func (s Span) summary() SpanSummary {
+	return SpanSummary{SpanID: s.SpanID, Service: s.Service, OperationName: s.OperationName, Duration: s.Duration, Tags: s.Tags}
+}
+
+// Failed returns true if span is marked as failed with "error" tag (Zipkin puts error message there) or OpenTelemetry
+// status.
+func (s Span) Failed() bool {
+	if e, ok := s.Tags["error"]; ok && e != "false" {
+		return true
+	}
+	return s.Tags["otel.status_code"] == "ERROR"
+}
+
+// Summary returns summary of the trace.
+func (t *Trace) Summary() TraceSummary {
+	ts := TraceSummary{TraceID: t.TraceID, Duration: t.End().Sub(t.Start()), SpanCount: len(t.Spans)}
+
+	services := map[string]struct{}{}
+	var slowest *Span
+	for i, s := range t.Spans {
+		if _, ok := services[s.Service]; !ok && s.Service != "" {
+			services[s.Service] = struct{}{}
+			ts.Services = append(ts.Services, s.Service)
+		}
+		if s.Failed() {
+			ts.ErrorSpans = append(ts.ErrorSpans, s.summary())
+		}
+		if slowest == nil || s.Duration > slowest.Duration {
+			slowest = &t.Spans[i]
+		}
+	}
+	sort.Strings(ts.Services)
+	if slowest != nil {
+		s := slowest.summary()
+		ts.SlowestSpan = &s
+	}
+	return ts
+}
@@ -0,0 +1,129 @@
+package correlator
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// TraceIDFormatHex validates hex trace ID of up to 32 characters and keeps it as found, so it matches logs and
+	// profiles recording it. W3C traceparent values are reduced to the trace ID.
+	TraceIDFormatHex = "hex"
+	// TraceIDFormatHex128 normalizes trace ID to 32 lower case hex characters, zero-padded (as Jaeger expects).
+	TraceIDFormatHex128 = "hex128"
+	// TraceIDFormatHex64 normalizes trace ID to 16 lower case hex characters, taking lower 64 bits of 128-bit IDs.
+	TraceIDFormatHex64 = "hex64"
+	// TraceIDFormatRaw leaves trace ID as it is.
+	TraceIDFormatRaw = "raw"
+)
+
+var defaultTraceIDLabels = []string{"traceID", "trace_id", "TraceID", "traceparent"}
+
+// TraceIDConfig specifies how to get trace ID from exemplar labels.
+type TraceIDConfig struct {
+	// Labels is an ordered list of exemplar label names that can hold trace ID. First non-empty one is used.
+	// W3C traceparent values (e.g. "00-<trace-id>-<parent-id>-01") are supported too.
+	// Defaults to ["traceID", "trace_id", "TraceID", "traceparent"].
+	Labels []string `json:",omitempty"`
+	// Format is the format trace ID is validated against and normalized to. One of "hex" (default), "hex128",
+	// "hex64" or "raw". Traces sources normalize the ID on their own, e.g. "jaeger" zero-pads it to 128 bits.
+	Format string `json:",omitempty"`
+}
+
+func (c *TraceIDConfig) validate() error {
+	if len(c.Labels) == 0 {
+		c.Labels = defaultTraceIDLabels
+	}
+	switch c.Format {
+	case "":
+		c.Format = TraceIDFormatHex
+	case TraceIDFormatHex, TraceIDFormatHex128, TraceIDFormatHex64, TraceIDFormatRaw:
+	default:
+		return errors.Errorf("unknown trace ID format %q", c.Format)
+	}
+	return nil
+}
+
+// traceID returns normalized trace ID from the given exemplar labels. Empty string is returned if none of the
+// configured labels is present.
+func (c TraceIDConfig) traceID(lset model.LabelSet) (string, error) {
+	for _, l := range c.Labels {
+		v := string(lset[model.LabelName(l)])
+		if v == "" {
+			continue
+		}
+		id, err := normalizeTraceID(v, c.Format)
+		if err != nil {
+			return "", errors.Wrapf(err, "exemplar label %v", l)
+		}
+		return id, nil
+	}
+	return "", nil
+}
+
+// inputTraceID validates trace ID given by the user and normalizes it to "hex128" format. IDs of up to 16
+// characters keep the "hex64" format instead, as that is how 64-bit IDs are printed e.g. in logs.
+func inputTraceID(id string) (string, error) {
+	n, err := normalizeTraceID(id, TraceIDFormatHex128)
+	if err != nil {
+		return "", err
+	}
+	if len(strings.TrimSpace(id)) <= 16 {
+		return n[16:], nil
+	}
+	return n, nil
+}
+
+// sameTraceID returns true if both IDs are the same trace ID, possibly in different formats.
+func sameTraceID(a, b string) bool {
+	na, erra := normalizeTraceID(a, TraceIDFormatHex128)
+	nb, errb := normalizeTraceID(b, TraceIDFormatHex128)
+	if erra != nil || errb != nil {
+		return a == b
+	}
+	return na == nb
+}
+
+// normalizeTraceID validates trace ID and converts it to the given format.
+func normalizeTraceID(id string, format string) (string, error) {
+	if format == TraceIDFormatRaw {
+		return id, nil
+	}
+
+	id = strings.TrimSpace(id)
+	// W3C Trace Context traceparent: version-traceid-parentid-flags.
+	if parts := strings.Split(id, "-"); len(parts) == 4 {
+		id = parts[1]
+	}
+	if len(id) == 0 || len(id) > 32 {
+		return "", errors.Errorf("trace ID %q has to have from 1 to 32 hex characters", id)
+	}
+	zero := true
+	for _, r := range strings.ToLower(id) {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return "", errors.Errorf("trace ID %q is not a hex string", id)
+		}
+		if r != '0' {
+			zero = false
+		}
+	}
+	if zero {
+		return "", errors.Errorf("trace ID %q is invalid, it has only zeros", id)
+	}
+
+	if format == TraceIDFormatHex {
+		return id, nil
+	}
+	id = strings.ToLower(id)
+	switch format {
+	case TraceIDFormatHex64:
+		if len(id) > 16 {
+			id = id[len(id)-16:]
+		}
+		return strings.Repeat("0", 16-len(id)) + id, nil
+	default:
+		return strings.Repeat("0", 32-len(id)) + id, nil
+	}
+}
@@ -0,0 +1,64 @@
+package correlator
+
+import (
+	"testing"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/prometheus/common/model"
+)
+
+func TestTraceIDConfig(t *testing.T) {
+	cfg := TraceIDConfig{}
+	testutil.Ok(t, cfg.validate())
+
+	for _, tcase := range []struct {
+		lset     model.LabelSet
+		format   string
+		expected string
+		err      bool
+	}{
+		{lset: model.LabelSet{"traceID": "0d89ae4c473862caa8d0e79cbdfc13e4"}, expected: "0d89ae4c473862caa8d0e79cbdfc13e4"},
+		{lset: model.LabelSet{"trace_id": "a8d0e79cbdfc13e4"}, expected: "a8d0e79cbdfc13e4"},
+		{lset: model.LabelSet{"trace_id": "D89AE4C473862CA"}, format: TraceIDFormatHex128, expected: "00000000000000000d89ae4c473862ca"},
+		{lset: model.LabelSet{"traceparent": "00-0d89ae4c473862caa8d0e79cbdfc13e4-b7ad6b7169203331-01"}, expected: "0d89ae4c473862caa8d0e79cbdfc13e4"},
+		{lset: model.LabelSet{"TraceID": "0d89ae4c473862caa8d0e79cbdfc13e4"}, format: TraceIDFormatHex64, expected: "a8d0e79cbdfc13e4"},
+		{lset: model.LabelSet{"traceID": "xyz"}, err: true},
+		{lset: model.LabelSet{"traceID": "00000000"}, err: true},
+		{lset: model.LabelSet{"span_id": "b7ad6b7169203331"}, expected: ""},
+	} {
+		t.Run(tcase.expected, func(t *testing.T) {
+			c := cfg
+			if tcase.format != "" {
+				c.Format = tcase.format
+			}
+			id, err := c.traceID(tcase.lset)
+			if tcase.err {
+				testutil.NotOk(t, err)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, id)
+		})
+	}
+}
+
+func TestInputTraceID(t *testing.T) {
+	for _, tcase := range []struct {
+		id       string
+		expected string
+	}{
+		{id: "0d89ae4c473862caa8d0e79cbdfc13e4", expected: "0d89ae4c473862caa8d0e79cbdfc13e4"},
+		{id: " A8D0E79CBDFC13E4 ", expected: "a8d0e79cbdfc13e4"},
+		{id: "d89ae4c473862ca", expected: "0d89ae4c473862ca"},
+		{id: "0a8d0e79cbdfc13e4", expected: "0000000000000000a8d0e79cbdfc13e4"},
+		{id: "00-0d89ae4c473862caa8d0e79cbdfc13e4-b7ad6b7169203331-01", expected: "0d89ae4c473862caa8d0e79cbdfc13e4"},
+	} {
+		t.Run(tcase.id, func(t *testing.T) {
+			id, err := inputTraceID(tcase.id)
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, id)
+		})
+	}
+	_, err := inputTraceID("xyz")
+	testutil.NotOk(t, err)
+}
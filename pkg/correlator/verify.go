@@ -0,0 +1,94 @@
+package correlator
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// verifyFunc returns true if data linked by the correlation exists. It can inline evidence of it in the correlation
+// and update the link if the data was found under a different ID.
+type verifyFunc func(ctx context.Context, corr *Correlation) (bool, error)
+
+func (c *Correlator) verifyLogs(s Source, scope Scope) verifyFunc {
+	if f, ok := s.(LogsFetcher); ok && c.cfg.Evidence.LogLines > 0 {
+		return func(ctx context.Context, corr *Correlation) (bool, error) {
+			lines, err := f.Logs(ctx, scope, c.cfg.Evidence.LogLines)
+			if err != nil || len(lines) == 0 {
+				return false, err
+			}
+			corr.Evidence = &Evidence{LogLines: lines}
+			// Link filtered by trace ID leads to data only if some lines have it, others are just the context.
+			return scope.TraceID == "" || lines[0].MatchesTrace, nil
+		}
+	}
+
+	v, ok := s.(LogsVerifier)
+	if !ok {
+		return nil
+	}
+	return func(ctx context.Context, _ *Correlation) (bool, error) {
+		return v.LogsExist(ctx, scope)
+	}
+}
+
+func (c *Correlator) verifyTrace(s Source, scope Scope) verifyFunc {
+	f, ok := s.(TraceFetcher)
+	if !ok {
+		return nil
+	}
+	return func(ctx context.Context, corr *Correlation) (bool, error) {
+		t, err := f.Trace(ctx, scope.TraceID)
+		if err != nil {
+			if errors.Cause(err) == ErrNotFound {
+				return false, nil
+			}
+			return false, err
+		}
+		if len(t.Spans) == 0 {
+			return false, nil
+		}
+		if !sameTraceID(t.TraceID, scope.TraceID) {
+			// Trace was found by other form of its ID, e.g. only its lower 64 bits, link that one.
+			sc := scope
+			sc.TraceID = t.TraceID
+			corr.URL = f.TraceURL(sc)
+		}
+		if !c.cfg.Evidence.DisableTraceSummary {
+			summary := t.Summary()
+			corr.Evidence = &Evidence{Trace: &summary}
+		}
+		return true, nil
+	}
+}
+
+func (c *Correlator) verifyTraces(s Source, scope Scope) verifyFunc {
+	v, ok := s.(TracesVerifier)
+	if !ok {
+		return nil
+	}
+	return func(ctx context.Context, _ *Correlation) (bool, error) {
+		return v.TracesExist(ctx, scope)
+	}
+}
+
+func (c *Correlator) verifyProfiles(s Source, scope Scope) verifyFunc {
+	if f, ok := s.(ProfilesFetcher); ok && c.cfg.Evidence.TopFunctions > 0 {
+		return func(ctx context.Context, corr *Correlation) (bool, error) {
+			ps, err := f.TopFunctions(ctx, scope, c.cfg.Evidence.TopFunctions)
+			if err != nil || ps == nil {
+				return false, err
+			}
+			corr.Evidence = &Evidence{Profile: ps}
+			return true, nil
+		}
+	}
+
+	v, ok := s.(ProfilesVerifier)
+	if !ok {
+		return nil
+	}
+	return func(ctx context.Context, _ *Correlation) (bool, error) {
+		return v.ProfilesExist(ctx, scope)
+	}
+}
@@ -0,0 +1,179 @@
+package correlator
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+)
+
+// ZipkinConfig is a "zipkin" source type specific configuration.
+type ZipkinConfig struct {
+	// TraceIDFormat is the format of trace IDs in Zipkin, "hex128" (default) or "hex64" for services reporting
+	// 64-bit trace IDs. 128-bit trace IDs (e.g. from exemplars) are converted to lower 64 bits in the latter
+	// case. With "hex128", trace that can't be found is also looked up by lower 64 bits of its ID.
+	TraceIDFormat string `json:",omitempty"`
+}
+
+type zipkinSource struct {
+	baseSource
+
+	cfg ZipkinConfig
+}
+
+func newZipkinSource(cfg SourceConfig, typeCfg []byte, _ log.Logger) (Source, error) {
+	b, err := newBaseSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &zipkinSource{baseSource: b}
+	if err := yaml.Unmarshal(typeCfg, &s.cfg); err != nil {
+		return nil, errors.Wrap(err, "parse Zipkin config")
+	}
+	switch s.cfg.TraceIDFormat {
+	case "":
+		s.cfg.TraceIDFormat = TraceIDFormatHex128
+	case TraceIDFormatHex128, TraceIDFormatHex64:
+	default:
+		return nil, errors.Errorf("unsupported Zipkin trace ID format %q", s.cfg.TraceIDFormat)
+	}
+	return s, nil
+}
+
+func (s *zipkinSource) Healthy(ctx context.Context) error {
+	return s.healthy(ctx, "/health")
+}
+
+// traceID returns trace ID in the format used by Zipkin.
+func (s *zipkinSource) traceID(id string) string {
+	n, err := normalizeTraceID(id, s.cfg.TraceIDFormat)
+	if err != nil {
+		// Let Zipkin deal with it.
+		return id
+	}
+	// Zipkin treats 128-bit IDs with zero high bits as 64-bit ones, use the shorter form.
+	if len(n) == 32 && strings.HasPrefix(n, "0000000000000000") {
+		return n[16:]
+	}
+	return n
+}
+
+func (s *zipkinSource) service(scope Scope) string {
+	if service := scope.Labels["service"]; service != "" {
+		return string(service)
+	}
+	return string(scope.Labels["job"])
+}
+
+func (s *zipkinSource) TraceURL(scope Scope) string {
+	return s.externalURL(scope.Tenant, "/zipkin/traces/"+url.PathEscape(s.traceID(scope.TraceID)))
+}
+
+func (s *zipkinSource) TracesSearchURL(scope Scope) string {
+	v := url.Values{}
+	if service := s.service(scope); service != "" {
+		v.Set("serviceName", service)
+	}
+	v.Set("lookback", "custom")
+	// Zipkin expects milliseconds.
+	v.Set("startTs", strconv.FormatInt(unixMillis(scope.Start), 10))
+	v.Set("endTs", strconv.FormatInt(unixMillis(scope.End), 10))
+	v.Set("limit", "20")
+	return s.externalURL(scope.Tenant, "/zipkin/?"+v.Encode())
+}
+
+func (s *zipkinSource) TracesExist(ctx context.Context, scope Scope) (bool, error) {
+	v := url.Values{}
+	if service := s.service(scope); service != "" {
+		v.Set("serviceName", service)
+	}
+	v.Set("endTs", strconv.FormatInt(unixMillis(scope.End), 10))
+	v.Set("lookback", strconv.FormatInt(unixMillis(scope.End)-unixMillis(scope.Start), 10))
+	v.Set("limit", "1")
+
+	var traces [][]zipkinSpan
+	if err := s.get(ctx, "/api/v2/traces?"+v.Encode(), &traces); err != nil {
+		return false, err
+	}
+	return len(traces) > 0, nil
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+	IPv4        string `json:"ipv4"`
+	IPv6        string `json:"ipv6"`
+	Port        int    `json:"port"`
+}
+
+// zipkinSpan is a span in Zipkin v2 JSON format.
+type zipkinSpan struct {
+	TraceID  string `json:"traceId"`
+	ID       string `json:"id"`
+	ParentID string `json:"parentId"`
+	Name     string `json:"name"`
+	// Timestamp and Duration are in microseconds.
+	Timestamp     int64             `json:"timestamp"`
+	Duration      int64             `json:"duration"`
+	LocalEndpoint *zipkinEndpoint   `json:"localEndpoint"`
+	Tags          map[string]string `json:"tags"`
+}
+
+func (s *zipkinSource) Trace(ctx context.Context, traceID string) (*Trace, error) {
+	id := s.traceID(traceID)
+	t, err := s.trace(ctx, id)
+	if errors.Cause(err) == ErrNotFound && s.cfg.TraceIDFormat == TraceIDFormatHex128 {
+		// Service might have reported only lower 64 bits of the trace ID.
+		if id64, nerr := normalizeTraceID(id, TraceIDFormatHex64); nerr == nil && id64 != id {
+			return s.trace(ctx, id64)
+		}
+	}
+	return t, err
+}
+
+func (s *zipkinSource) trace(ctx context.Context, traceID string) (*Trace, error) {
+	var spans []zipkinSpan
+	if err := s.get(ctx, "/api/v2/trace/"+url.PathEscape(traceID), &spans); err != nil {
+		if isNotFound(err) {
+			return nil, errors.Wrapf(ErrNotFound, "%v source: trace %v", s.Name(), traceID)
+		}
+		return nil, err
+	}
+	if len(spans) == 0 {
+		return nil, errors.Wrapf(ErrNotFound, "%v source: trace %v", s.Name(), traceID)
+	}
+
+	t := &Trace{TraceID: spans[0].TraceID, Spans: make([]Span, 0, len(spans))}
+	for _, sp := range spans {
+		span := Span{
+			SpanID:        sp.ID,
+			ParentSpanID:  sp.ParentID,
+			OperationName: sp.Name,
+			Start:         time.Unix(0, sp.Timestamp*int64(time.Microsecond)),
+			Duration:      time.Duration(sp.Duration) * time.Microsecond,
+			Tags:          sp.Tags,
+		}
+		if e := sp.LocalEndpoint; e != nil {
+			span.Service = e.ServiceName
+			span.Resource = map[string]string{}
+			if e.IPv4 != "" {
+				span.Resource["ipv4"] = e.IPv4
+			}
+			if e.IPv6 != "" {
+				span.Resource["ipv6"] = e.IPv6
+			}
+			if e.Port != 0 {
+				span.Resource["port"] = strconv.Itoa(e.Port)
+			}
+			if len(span.Resource) == 0 {
+				span.Resource = nil
+			}
+		}
+		t.Spans = append(t.Spans, span)
+	}
+	return t, nil
+}
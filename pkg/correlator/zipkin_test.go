@@ -0,0 +1,63 @@
+package correlator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+)
+
+func TestZipkinSource_Trace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Legacy service reported only 64-bit trace ID.
+		if r.URL.Path != "/api/v2/trace/a8d0e79cbdfc13e4" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`[
+{"traceId":"a8d0e79cbdfc13e4","id":"b7ad6b7169203331","name":"get /ping","timestamp":1650000000000000,"duration":20000,"localEndpoint":{"serviceName":"ping","ipv4":"10.0.0.1"}},
+{"traceId":"a8d0e79cbdfc13e4","parentId":"b7ad6b7169203331","id":"00f067aa0ba902b7","name":"query","timestamp":1650000000005000,"duration":30000,"localEndpoint":{"serviceName":"db"},"tags":{"error":"connection refused"}}
+]`))
+	}))
+	defer srv.Close()
+
+	cfg := SourceConfig{Name: "zipkin", Type: "zipkin", InternalEndpoint: strings.TrimPrefix(srv.URL, "http://"), ExternalEndpoint: "localhost:9411"}
+	s, err := newZipkinSource(cfg, nil, log.NewNopLogger())
+	testutil.Ok(t, err)
+
+	trace, err := s.(TraceFetcher).Trace(context.Background(), "0d89ae4c473862caa8d0e79cbdfc13e4")
+	testutil.Ok(t, err)
+	testutil.Equals(t, TraceSummary{
+		TraceID:     "a8d0e79cbdfc13e4",
+		Duration:    35 * time.Millisecond,
+		SpanCount:   2,
+		Services:    []string{"db", "ping"},
+		ErrorSpans:  []SpanSummary{{SpanID: "00f067aa0ba902b7", Service: "db", OperationName: "query", Duration: 30 * time.Millisecond, Tags: map[string]string{"error": "connection refused"}}},
+		SlowestSpan: &SpanSummary{SpanID: "00f067aa0ba902b7", Service: "db", OperationName: "query", Duration: 30 * time.Millisecond, Tags: map[string]string{"error": "connection refused"}},
+	}, trace.Summary())
+	testutil.Equals(t, map[string]string{"ipv4": "10.0.0.1"}, trace.Root().Resource)
+	scope := Scope{TraceID: "0d89ae4c473862caa8d0e79cbdfc13e4"}
+	testutil.Equals(t, "http://localhost:9411/zipkin/traces/0d89ae4c473862caa8d0e79cbdfc13e4", s.(TracesSource).TraceURL(scope))
+
+	// Verified link points to the trace under the ID it was found by.
+	c := &Correlator{}
+	corr := Correlation{URL: s.(TracesSource).TraceURL(scope)}
+	ok, err := c.verifyTrace(s, scope)(context.Background(), &corr)
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, "http://localhost:9411/zipkin/traces/a8d0e79cbdfc13e4", corr.URL)
+	testutil.Equals(t, "a8d0e79cbdfc13e4", corr.Evidence.Trace.TraceID)
+
+	_, err = s.(TraceFetcher).Trace(context.Background(), "1d89ae4c473862caa8d0e79cbdfc13e5")
+	testutil.Equals(t, ErrNotFound, errors.Cause(err))
+
+	s, err = newZipkinSource(cfg, []byte("traceIDFormat: hex64"), log.NewNopLogger())
+	testutil.Ok(t, err)
+	testutil.Equals(t, "http://localhost:9411/zipkin/traces/a8d0e79cbdfc13e4", s.(TracesSource).TraceURL(Scope{TraceID: "0d89ae4c473862caa8d0e79cbdfc13e4"}))
+}